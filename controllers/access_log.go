@@ -0,0 +1,88 @@
+package controllers
+
+import (
+	"freegfw/database"
+	"freegfw/models"
+	"freegfw/services"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetAccessLogConfig(c *gin.Context) {
+	c.JSON(http.StatusOK, services.LoadAccessLogConfig())
+}
+
+func UpdateAccessLogConfig(c *gin.Context) {
+	before := services.LoadAccessLogConfig()
+	payload := before
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := services.SaveAccessLogConfig(payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	recordAudit(c, "UpdateAccessLogConfig", "", before, payload)
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// GetAccessLogs returns recorded connections newest first.
+// Query: user, from and to (RFC 3339 or unix seconds), page (default 1),
+// pageSize (default 50, max 500).
+func GetAccessLogs(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if page < 1 {
+		page = 1
+	}
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "50"))
+	if pageSize < 1 {
+		pageSize = 50
+	}
+	if pageSize > 500 {
+		pageSize = 500
+	}
+
+	query := database.DB.Model(&models.AccessLog{})
+	if user := c.Query("user"); user != "" {
+		query = query.Where("user = ?", user)
+	}
+	for _, bound := range []struct{ param, cond string }{
+		{"from", "started_at >= ?"},
+		{"to", "started_at <= ?"},
+	} {
+		v := c.Query(bound.param)
+		if v == "" {
+			continue
+		}
+		t, err := parseTimeParam(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + bound.param})
+			return
+		}
+		query = query.Where(bound.cond, t)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var logs []models.AccessLog
+	query.Order("started_at DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&logs)
+
+	c.JSON(http.StatusOK, gin.H{
+		"items":    logs,
+		"total":    total,
+		"page":     page,
+		"pageSize": pageSize,
+	})
+}
+
+func parseTimeParam(v string) (time.Time, error) {
+	if sec, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
@@ -0,0 +1,162 @@
+package controllers
+
+import (
+	"encoding/json"
+	"freegfw/database"
+	"freegfw/models"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const redacted = "[REDACTED]"
+
+// recordAudit stores a mutating admin call. before and after are any JSON
+// serialisable values (nil when the object did not exist); only the
+// top-level fields that differ are kept, with credentials redacted.
+func recordAudit(c *gin.Context, action, targetID string, before, after interface{}) {
+	actor, _, _ := c.Request.BasicAuth()
+	diff, _ := json.Marshal(auditDiff(toAuditMap(before), toAuditMap(after)))
+
+	entry := models.AuditLog{
+		Actor:    actor,
+		IP:       c.ClientIP(),
+		Method:   c.Request.Method,
+		Route:    c.FullPath(),
+		Action:   action,
+		TargetID: targetID,
+		Diff:     diff,
+	}
+	if err := database.DB.Create(&entry).Error; err != nil {
+		slog.Error("Failed to write audit log", "action", action, "err", err)
+	}
+}
+
+func toAuditMap(v interface{}) map[string]interface{} {
+	m := map[string]interface{}{}
+	if v == nil {
+		return m
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return m
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		// Not an object, keep it under a single key
+		var raw interface{}
+		json.Unmarshal(b, &raw)
+		m = map[string]interface{}{"value": raw}
+	}
+	return redactAudit(m).(map[string]interface{})
+}
+
+func auditDiff(before, after map[string]interface{}) map[string]interface{} {
+	diff := map[string]interface{}{}
+	for k, b := range before {
+		if k == "createdAt" || k == "updatedAt" {
+			continue
+		}
+		a, ok := after[k]
+		if !ok || !reflect.DeepEqual(a, b) {
+			diff[k] = gin.H{"before": b, "after": a}
+		}
+	}
+	for k, a := range after {
+		if k == "createdAt" || k == "updatedAt" {
+			continue
+		}
+		if _, ok := before[k]; !ok {
+			diff[k] = gin.H{"before": nil, "after": a}
+		}
+	}
+	return diff
+}
+
+func isSensitiveKey(key string) bool {
+	k := strings.ToLower(key)
+	// Link codes are bearer credentials for the peer
+	if k == "uuid" || k == "users" || k == "link" {
+		return true
+	}
+	for _, s := range []string{"password", "private_key", "privatekey", "secret", "token", "code"} {
+		if strings.Contains(k, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func redactAudit(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, item := range val {
+			if isSensitiveKey(k) {
+				val[k] = redacted
+			} else {
+				val[k] = redactAudit(item)
+			}
+		}
+		return val
+	case []interface{}:
+		for i, item := range val {
+			val[i] = redactAudit(item)
+		}
+		return val
+	}
+	return v
+}
+
+// settingsSnapshot returns the decoded values of the given setting keys.
+func settingsSnapshot(keys ...string) map[string]interface{} {
+	var settings []models.Setting
+	database.DB.Where("key IN ?", keys).Find(&settings)
+	res := make(map[string]interface{}, len(settings))
+	for _, s := range settings {
+		var v interface{}
+		if len(s.Value) > 0 {
+			if err := json.Unmarshal(s.Value, &v); err != nil {
+				v = string(s.Value)
+			}
+		}
+		res[s.Key] = v
+	}
+	return res
+}
+
+// GetAuditLogs returns audit entries newest first.
+// Query: page (default 1), pageSize (default 50, max 500), action.
+func GetAuditLogs(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if page < 1 {
+		page = 1
+	}
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "50"))
+	if pageSize < 1 {
+		pageSize = 50
+	}
+	if pageSize > 500 {
+		pageSize = 500
+	}
+
+	query := database.DB.Model(&models.AuditLog{})
+	if action := c.Query("action"); action != "" {
+		query = query.Where("action = ?", action)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var logs []models.AuditLog
+	query.Order("id DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&logs)
+
+	c.JSON(http.StatusOK, gin.H{
+		"items":    logs,
+		"total":    total,
+		"page":     page,
+		"pageSize": pageSize,
+	})
+}
@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"freegfw/utils"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultClashTemplate is offered as a starting point for a custom Clash or
+// mihomo profile. It matches the built-in profile plus region groups.
+const defaultClashTemplate = `port: 7890
+socks-port: 7891
+allow-lan: true
+mode: rule
+log-level: info
+external-controller: 127.0.0.1:9090
+
+# $proxies stands for the user's nodes
+proxies:
+  - $proxies
+
+proxy-groups:
+  - name: Proxy
+    type: select
+    # $all is every node, $regions every region group
+    proxies: [Auto, $regions, $all]
+  - name: Auto
+    type: url-test
+    url: http://www.gstatic.com/generate_204
+    interval: 300
+    tolerance: 50
+    proxies: [$all]
+  # One url-test group per region found in the node titles
+  - $regions
+
+rules:
+  - GEOIP,LAN,DIRECT
+  - GEOIP,CN,DIRECT
+  - MATCH,Proxy
+`
+
+// clashTemplateSample is what templates are validated against.
+var clashTemplateSample = []map[string]interface{}{
+	{"name": "🇭🇰 HK 01", "type": "vless", "server": "example.com", "port": 443, "uuid": "uuid"},
+	{"name": "Tokyo", "type": "trojan", "server": "example.com", "port": 443, "password": "uuid"},
+}
+
+// GetClashTemplate returns the custom Clash profile template ("" when the
+// built-in profile is used) together with a starting point for one.
+func GetClashTemplate(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"template": textSetting("clash_template"),
+		"default":  defaultClashTemplate,
+	})
+}
+
+// UpdateClashTemplate stores a custom Clash profile template. An empty
+// template restores the built-in profile.
+func UpdateClashTemplate(c *gin.Context) {
+	var payload struct {
+		Template string `json:"template"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if strings.TrimSpace(payload.Template) != "" {
+		cfg, err := utils.RenderClashTemplate(payload.Template, clashTemplateSample)
+		if err == nil {
+			err = utils.CheckClashConfig(cfg)
+		}
+		if err == nil {
+			_, err = yaml.Marshal(cfg)
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	before := textSetting("clash_template")
+	saveTextSetting("clash_template", payload.Template)
+	recordAudit(c, "UpdateClashTemplate", "", before, payload.Template)
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
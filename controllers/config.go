@@ -79,6 +79,14 @@ func UpdateConfig(c *gin.Context) {
 	}
 
 	allowed := []string{"username", "password", "title", "warp_enabled"}
+	var changed []string
+	for _, key := range allowed {
+		if _, ok := payload[key]; ok {
+			changed = append(changed, key)
+		}
+	}
+	before := settingsSnapshot(changed...)
+
 	for _, key := range allowed {
 		if val, ok := payload[key]; ok {
 			jsonVal, _ := json.Marshal(val) // Handle null/empty logic
@@ -95,6 +103,10 @@ func UpdateConfig(c *gin.Context) {
 		}
 	}
 
+	if _, ok := payload["title"]; ok {
+		services.NotifyLinkPeers()
+	}
+	recordAudit(c, "UpdateConfig", "", before, settingsSnapshot(changed...))
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
@@ -102,17 +114,30 @@ func ReloadConfig(c *gin.Context) {
 	core := services.NewCoreService()
 	core.Refresh()
 	core.Start()
+	recordAudit(c, "ReloadConfig", "", nil, nil)
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
+// resetKeepSettings survive ResetConfig. The identity key is kept because
+// linked peers have pinned it.
+var resetKeepSettings = []string{"letsencrypt_domain", "letsencrypt_email", "letsencrypt_updated_at", "identity_key"}
+
 func ResetConfig(c *gin.Context) {
-	// Delete settings except letsencrypt
-	database.DB.Where("key NOT IN ?", []string{"letsencrypt_domain", "letsencrypt_email", "letsencrypt_updated_at"}).Delete(&models.Setting{})
+	var settingKeys []string
+	database.DB.Model(&models.Setting{}).Where("key NOT IN ?", resetKeepSettings).Pluck("key", &settingKeys)
+	var userCount int64
+	database.DB.Model(&models.User{}).Count(&userCount)
+
+	// Delete settings except letsencrypt and the identity key
+	database.DB.Where("key NOT IN ?", resetKeepSettings).Delete(&models.Setting{})
 	// Truncate Users
 	database.DB.Exec("DELETE FROM users") // SQLite doesn't have TRUNCATE
+	services.InvalidateUserIndex()
+	services.NotifyLinkPeers()
 
 	core := services.NewCoreService()
 	core.Kill()
+	recordAudit(c, "ResetConfig", "", gin.H{"settings": settingKeys, "userCount": userCount}, nil)
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
@@ -125,6 +150,7 @@ func SetTitle(c *gin.Context) {
 		return
 	}
 
+	before := settingsSnapshot("title")
 	var s models.Setting
 	if database.DB.Where("key = ?", "title").Limit(1).Find(&s).RowsAffected == 0 {
 		s = models.Setting{Key: "title"}
@@ -132,6 +158,8 @@ func SetTitle(c *gin.Context) {
 	val, _ := json.Marshal(payload.Title)
 	s.Value = models.JSON(val)
 	database.DB.Save(&s)
+	services.NotifyLinkPeers()
+	recordAudit(c, "SetTitle", "", before, settingsSnapshot("title"))
 
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
@@ -0,0 +1,296 @@
+package controllers
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"freegfw/database"
+	"freegfw/models"
+	"freegfw/sharelink"
+	"freegfw/utils"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultLandingPage is used unless a custom template is stored in the
+// "landing_page" setting.
+//
+//go:embed landing.html
+var defaultLandingPage string
+
+// landingLanguages are the page languages, matching the READMEs. The first
+// one is the fallback.
+var landingLanguages = []string{"zh", "en", "fa"}
+
+var landingStrings = map[string]map[string]string{
+	"zh": {
+		"upload":    "上传",
+		"download":  "下载",
+		"used":      "已用流量",
+		"quota":     "流量",
+		"unlimited": "不限",
+		"import":    "导入订阅",
+		"import_to": "导入到",
+		"nodes":     "节点",
+		"expires":   "到期时间",
+	},
+	"en": {
+		"upload":    "Upload",
+		"download":  "Download",
+		"used":      "Used",
+		"quota":     "Quota",
+		"unlimited": "Unlimited",
+		"import":    "Import subscription",
+		"import_to": "Import to",
+		"nodes":     "Nodes",
+		"expires":   "Expires",
+	},
+	"fa": {
+		"upload":    "آپلود",
+		"download":  "دانلود",
+		"used":      "مصرف شده",
+		"quota":     "حجم",
+		"unlimited": "نامحدود",
+		"import":    "افزودن اشتراک",
+		"import_to": "افزودن به",
+		"nodes":     "سرورها",
+		"expires":   "انقضا",
+	},
+}
+
+// landingPageData is what the landing page template is executed with.
+type landingPageData struct {
+	Lang      string
+	Dir       string // "rtl" for Persian
+	Languages []landingLanguageLink
+	T         map[string]string // UI strings in Lang
+
+	Title    string
+	Username string
+	URL      string       // subscription URL
+	QR       template.URL // subscription QR code as a data URI
+
+	Upload      int64
+	Download    int64
+	Used        int64
+	Quota       int64 // 0 means unlimited
+	UsedPercent float64
+	Expires     time.Time // expiry of a signed link, zero otherwise
+
+	Apps  []landingApp
+	Nodes []landingNode
+}
+
+// landingLanguageLink switches the page to another language, keeping the
+// query of the subscription URL (e.g. the signature of a signed link).
+type landingLanguageLink struct {
+	Lang string
+	URL  string
+}
+
+func landingLanguageLinks(subPath string) []landingLanguageLink {
+	links := make([]landingLanguageLink, len(landingLanguages))
+	for i, lang := range landingLanguages {
+		links[i] = landingLanguageLink{lang, withQuery(subPath, "lang", lang)}
+	}
+	return links
+}
+
+type landingApp struct {
+	Name string
+	URL  template.URL
+}
+
+type landingNode struct {
+	Title string
+	Link  string
+	QR    template.URL
+}
+
+var landingFuncs = template.FuncMap{
+	"bytes": formatBytes,
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.2f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// landingLanguage picks the page language from ?lang= or Accept-Language.
+func landingLanguage(c *gin.Context) string {
+	if lang := c.Query("lang"); landingStrings[lang] != nil {
+		return lang
+	}
+	for _, part := range strings.Split(c.GetHeader("Accept-Language"), ",") {
+		tag, _, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag, _, _ = strings.Cut(strings.ToLower(tag), "-")
+		if landingStrings[tag] != nil {
+			return tag
+		}
+	}
+	return landingLanguages[0]
+}
+
+// landingApps returns the import deep links of the clients the page offers.
+func landingApps(subURL, title string) []landingApp {
+	withFormat := func(format string) string {
+		return withQuery(subURL, "format", format)
+	}
+	q := url.QueryEscape
+	return []landingApp{
+		{"Shadowrocket", template.URL("sub://" + base64.StdEncoding.EncodeToString([]byte(subURL+"#"+title)))},
+		{"Hiddify", template.URL("hiddify://import/" + subURL + "#" + q(title))},
+		{"sing-box", template.URL("sing-box://import-remote-profile?url=" + q(withFormat("sing-box")) + "#" + q(title))},
+		{"v2rayNG", template.URL("v2rayng://install-config?url=" + q(subURL) + "&name=" + q(title))},
+		{"Streisand", template.URL("streisand://import/" + subURL + "#" + q(title))},
+		{"Karing", template.URL("karing://install-config?url=" + q(subURL) + "&name=" + q(title))},
+		{"Clash", template.URL("clash://install-config?url=" + q(withFormat("clash")) + "&name=" + q(title))},
+		{"Stash", template.URL("stash://install-config?url=" + q(withFormat("clash")))},
+		{"Surge", template.URL("surge:///install-config?url=" + q(withFormat("surge")))},
+	}
+}
+
+// textSetting returns a setting stored as a JSON string, "" if unset.
+func textSetting(key string) string {
+	var s models.Setting
+	database.DB.Where("key = ?", key).Limit(1).Find(&s)
+	var text string
+	if len(s.Value) > 0 {
+		json.Unmarshal(s.Value, &text)
+	}
+	return text
+}
+
+func saveTextSetting(key, text string) {
+	var s models.Setting
+	if database.DB.Where("key = ?", key).Limit(1).Find(&s).RowsAffected == 0 {
+		s = models.Setting{Key: key}
+	}
+	val, _ := json.Marshal(text)
+	s.Value = models.JSON(val)
+	database.DB.Save(&s)
+}
+
+// landingQR renders content as an inline SVG QR code, so viewing the page
+// is a single subscription fetch rather than one per image.
+func landingQR(content string) template.URL {
+	svg, err := utils.QRSVG(content)
+	if err != nil {
+		return ""
+	}
+	return template.URL("data:image/svg+xml;base64," + base64.StdEncoding.EncodeToString([]byte(svg)))
+}
+
+func renderLandingPage(c *gin.Context, access subscriptionAccess) {
+	user := access.User
+	lang := landingLanguage(c)
+	subURL := subscriptionBaseURL(c) + access.url("")
+
+	data := landingPageData{
+		Lang:      lang,
+		Dir:       "ltr",
+		Languages: landingLanguageLinks(access.url("")),
+		T:         landingStrings[lang],
+		Title:     siteTitle(),
+		Username:  user.Username,
+		URL:       subURL,
+		QR:        landingQR(subURL),
+		Upload:    user.Upload,
+		Download:  user.Download,
+		Used:      user.Upload + user.Download,
+		Quota:     user.Quota,
+		Expires:   access.Expires,
+	}
+	if lang == "fa" {
+		data.Dir = "rtl"
+	}
+	if data.Quota > 0 {
+		data.UsedPercent = min(float64(data.Used)*100/float64(data.Quota), 100)
+	}
+	data.Apps = landingApps(subURL, data.Title)
+	for _, n := range subscriptionNodes(c, user) {
+		if link := sharelink.Generate(n, user.UUID, user.Username); link != "" {
+			data.Nodes = append(data.Nodes, landingNode{
+				Title: n.Title,
+				Link:  link,
+				QR:    landingQR(link),
+			})
+		}
+	}
+
+	source := textSetting("landing_page")
+	if strings.TrimSpace(source) == "" {
+		source = defaultLandingPage
+	}
+	tmpl, err := template.New("landing").Funcs(landingFuncs).Parse(source)
+	if err != nil {
+		// A broken custom template must not lock users out
+		tmpl = template.Must(template.New("landing").Funcs(landingFuncs).Parse(defaultLandingPage))
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", buf.Bytes())
+}
+
+// GetLandingPage returns the custom landing page template ("" when the
+// built-in one is used) together with the built-in one.
+func GetLandingPage(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"template": textSetting("landing_page"),
+		"default":  defaultLandingPage,
+	})
+}
+
+// UpdateLandingPage stores a custom landing page template. An empty
+// template restores the built-in page.
+func UpdateLandingPage(c *gin.Context) {
+	var payload struct {
+		Template string `json:"template"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if strings.TrimSpace(payload.Template) != "" {
+		tmpl, err := template.New("landing").Funcs(landingFuncs).Parse(payload.Template)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		sample := landingPageData{
+			Lang: "en", Dir: "ltr", Languages: landingLanguageLinks("/subscribe/uuid"), T: landingStrings["en"],
+			Title: "FreeGFW", Username: "user", URL: "https://example.com/subscribe/uuid", QR: landingQR("https://example.com/subscribe/uuid"), Expires: time.Now().Add(24 * time.Hour),
+			Apps:  landingApps("https://example.com/subscribe/uuid", "FreeGFW"),
+			Nodes: []landingNode{{Title: "node", Link: "vless://uuid@example.com:443#node", QR: landingQR("vless://uuid@example.com:443#node")}},
+		}
+		if err := tmpl.Execute(&bytes.Buffer{}, sample); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	before := textSetting("landing_page")
+
+	saveTextSetting("landing_page", payload.Template)
+	recordAudit(c, "UpdateLandingPage", "", before, payload.Template)
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
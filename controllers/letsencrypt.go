@@ -30,6 +30,7 @@ func InitLetsEncrypt(c *gin.Context) {
 		payload.Domain = ip
 	}
 
+	before := settingsSnapshot("letsencrypt_email", "letsencrypt_domain")
 	err := services.ApplyCertificate(payload.Domain, payload.Email)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Certificate application failed: " + err.Error()})
@@ -39,6 +40,7 @@ func InitLetsEncrypt(c *gin.Context) {
 	saveLetSetting("letsencrypt_email", payload.Email)
 	saveLetSetting("letsencrypt_domain", payload.Domain)
 	saveLetSetting("letsencrypt_updated_at", time.Now().UnixMilli())
+	recordAudit(c, "InitLetsEncrypt", payload.Domain, before, settingsSnapshot("letsencrypt_email", "letsencrypt_domain"))
 
 	c.JSON(http.StatusOK, gin.H{"success": true})
 	go func() {
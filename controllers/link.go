@@ -1,19 +1,21 @@
 package controllers
 
 import (
-	"bytes"
 	"context"
 	"crypto/sha1"
 	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"freegfw/database"
 	"freegfw/models"
 	"freegfw/services"
 	"freegfw/utils"
+	"io"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
@@ -27,12 +29,52 @@ var (
 
 func CreateLink(c *gin.Context) {
 	code := utils.RandomUUID()
+	expires := time.Now().Add(10 * time.Minute)
 	linkMu.Lock()
-	linkCache[code] = time.Now().Add(10 * time.Minute).Unix()
+	linkCache[code] = expires.Unix()
 	linkMu.Unlock()
+	recordAudit(c, "CreateLink", "", nil, gin.H{"expires": expires})
 
+	// The fragment carries our identity key so the peer can pin it before
+	// first contact; it is never sent over the wire
 	link, _ := services.GetMyLink(code)
-	c.JSON(http.StatusOK, gin.H{"link": link})
+	c.JSON(http.StatusOK, gin.H{"link": link + "#" + services.IdentityPublicKey()})
+}
+
+// GetLinkKey returns this node's identity key, for the admin of a peer to
+// confirm with SetLinkKey.
+func GetLinkKey(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"key": services.IdentityPublicKey()})
+}
+
+// SetLinkKey pins the identity key of a link's peer. Links made before nodes
+// had identity keys sync again once the admin has confirmed the key the
+// peer's admin reads from GetLinkKey.
+func SetLinkKey(c *gin.Context) {
+	id := c.Param("id")
+	var payload struct {
+		Key string `json:"key"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	payload.Key = strings.TrimSpace(payload.Key)
+	if !services.ValidLinkKey(payload.Key) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid key"})
+		return
+	}
+
+	var link models.Link
+	if database.DB.Limit(1).Find(&link, id).RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Link not found"})
+		return
+	}
+	before := gin.H{"peerKey": link.PeerKey}
+	database.DB.Model(&link).Update("peer_key", payload.Key)
+	services.RequestLinkSync(link.ID)
+	recordAudit(c, "SetLinkKey", id, before, gin.H{"peerKey": payload.Key})
+	c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
 func ListLinks(c *gin.Context) {
@@ -43,7 +85,12 @@ func ListLinks(c *gin.Context) {
 
 func DeleteLink(c *gin.Context) {
 	id := c.Param("id")
+	var before models.Link
+	database.DB.Limit(1).Find(&before, id)
 	database.DB.Delete(&models.Link{}, id)
+	if before.ID != 0 {
+		recordAudit(c, "DeleteLink", id, before, nil)
+	}
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
@@ -56,8 +103,14 @@ func SwapLink(c *gin.Context) {
 		return
 	}
 
+	target, peerKey := services.SplitLinkKey(strings.TrimSpace(payload.Link))
+	if !services.ValidLinkKey(peerKey) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Link has no identity key, create a new link on the peer"})
+		return
+	}
+
 	var checkLink models.Link
-	if database.DB.Where("link = ?", payload.Link).First(&checkLink).Error == nil {
+	if database.DB.Where("link = ?", target).First(&checkLink).Error == nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Link already exists"})
 		return
 	}
@@ -80,18 +133,28 @@ func SwapLink(c *gin.Context) {
 		Transport: transport,
 	}
 	body, _ := json.Marshal(map[string]string{"link": myLink})
-	resp, err := client.Post(payload.Link, "application/json", bytes.NewBuffer(body))
+	req, nonce, err := services.NewLinkRequest(target, body)
+	var resp *http.Response
+	if err == nil {
+		resp, err = client.Do(req)
+	}
 
 	if err == nil {
 		defer resp.Body.Close()
 		var res map[string]interface{}
 		content, _ := ioutil.ReadAll(resp.Body)
+		key, err := services.VerifyLinkResponse(resp, content, nonce, peerKey)
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"success": false, "error": err.Error()})
+			return
+		}
 		json.Unmarshal(content, &res)
 		if res["success"] == true {
 			l := models.Link{
 				LocalCode:      code,
-				Link:           payload.Link,
+				Link:           target,
 				LastSyncStatus: "success",
+				PeerKey:        key,
 				LastSyncAt:     func(v int64) *int64 { return &v }(time.Now().Unix()),
 			}
 
@@ -135,6 +198,7 @@ func SwapLink(c *gin.Context) {
 				l.ID = existing.ID
 			}
 			database.DB.Save(&l)
+			recordAudit(c, "SwapLink", fmt.Sprint(l.ID), nil, l)
 
 			// Rebuild and restart the running core so that the synced remote
 			// users (now stored in Link.Users) are injected into the inbound's
@@ -149,10 +213,10 @@ func SwapLink(c *gin.Context) {
 			// the sync loop's etag-match path never triggers a restart.
 			core := services.NewCoreService()
 			if err := core.Refresh(); err != nil {
-				log.Println("Failed to refresh core after link swap:", err)
+				slog.Error("Failed to refresh core after link swap", "err", err)
 			}
 			if err := core.HotReloadUsers(); err != nil {
-				log.Println("Hot reload failed after link swap, falling back to restart:", err)
+				slog.Warn("Hot reload failed after link swap, falling back to restart", "err", err)
 				core.Restart()
 			}
 
@@ -167,7 +231,8 @@ func BindLink(c *gin.Context) {
 	var payload struct {
 		Link string `json:"link"`
 	}
-	c.ShouldBindJSON(&payload)
+	body, _ := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+	json.Unmarshal(body, &payload)
 
 	code := c.Param("code")
 
@@ -178,24 +243,33 @@ func BindLink(c *gin.Context) {
 	if ok {
 		if time.Now().Unix() < exp {
 			if payload.Link == "" {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "Missing link"})
+				respondLink(c, http.StatusBadRequest, gin.H{"error": "Missing link"})
+				return
+			}
+			// A fresh code proves the peer was invited; its signature proves
+			// it holds the key we pin
+			peerKey, err := services.VerifyLinkRequest(c.Request, body, "")
+			if err != nil {
+				respondLink(c, http.StatusUnauthorized, gin.H{"success": false, "message": err.Error()})
 				return
 			}
 			var checkLink models.Link
 			if database.DB.Where("link = ?", payload.Link).First(&checkLink).Error == nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "Link already exists"})
+				respondLink(c, http.StatusBadRequest, gin.H{"error": "Link already exists"})
 				return
 			}
 			l := models.Link{
 				LocalCode:      code,
 				Link:           payload.Link,
 				LastSyncStatus: "pending",
+				PeerKey:        peerKey,
 			}
 			database.DB.Create(&l)
+			services.RequestLinkSync(l.ID)
 			linkMu.Lock()
 			delete(linkCache, code)
 			linkMu.Unlock()
-			c.JSON(http.StatusOK, getHandshakeData())
+			respondLink(c, http.StatusOK, getHandshakeData(l))
 			return
 		} else {
 			linkMu.Lock()
@@ -204,16 +278,56 @@ func BindLink(c *gin.Context) {
 		}
 	}
 
-	var existingLink models.Link
-	if database.DB.Where("local_code = ?", code).Limit(1).Find(&existingLink).RowsAffected > 0 {
-		c.JSON(http.StatusOK, getHandshakeData())
-		return
+	if existingLink, ok := authenticateLinkPeer(c, code, body); ok {
+		respondLink(c, http.StatusOK, getHandshakeData(existingLink))
 	}
+}
 
-	c.JSON(http.StatusUnauthorized, gin.H{"success": false, "message": "Unauthorized"})
+// NotifyLink is called by a peer whose users, server or title changed, so
+// we sync from it now rather than at the next poll.
+func NotifyLink(c *gin.Context) {
+	body, _ := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+	if link, ok := authenticateLinkPeer(c, c.Param("code"), body); ok {
+		services.RequestLinkSync(link.ID)
+		respondLink(c, http.StatusOK, gin.H{"success": true})
+	}
 }
 
-func getHandshakeData() gin.H {
+// authenticateLinkPeer finds the link the code belongs to and checks that
+// the request is signed by its peer. On failure it writes the response and
+// returns false.
+func authenticateLinkPeer(c *gin.Context, code string, body []byte) (models.Link, bool) {
+	var link models.Link
+	if database.DB.Where("local_code = ?", code).Limit(1).Find(&link).RowsAffected == 0 {
+		respondLink(c, http.StatusUnauthorized, gin.H{"success": false, "message": "Unauthorized"})
+		return link, false
+	}
+	// The code of a link made before nodes had identity keys is only a
+	// bearer token, so whoever presents it is not trusted until an admin
+	// confirms the peer's key
+	err := services.ErrLinkKeyMissing
+	if link.PeerKey != "" {
+		_, err = services.VerifyLinkRequest(c.Request, body, link.PeerKey)
+	}
+	if err != nil {
+		slog.Warn("Rejected link request", "link", link.ID, "ip", c.ClientIP(), "err", err)
+		respondLink(c, http.StatusUnauthorized, gin.H{"success": false, "message": err.Error()})
+		return link, false
+	}
+	return link, true
+}
+
+// respondLink writes a JSON response to a peer, signed so the peer can tell
+// it came from us.
+func respondLink(c *gin.Context, status int, obj interface{}) {
+	body, _ := json.Marshal(obj)
+	services.SignLinkResponse(c.Writer.Header(), c.Request, body)
+	c.Data(status, "application/json; charset=utf-8", body)
+}
+
+// getHandshakeData is what a peer syncs from us. Only users allowed to use
+// that peer are shared, so the peer rejects everyone else.
+func getHandshakeData(peer models.Link) gin.H {
 	var ipSetting models.Setting
 	database.DB.Where("key = ?", "ip").Limit(1).Find(&ipSetting)
 	var ip string
@@ -228,6 +342,9 @@ func getHandshakeData() gin.H {
 	database.DB.Find(&users)
 	var uuids []string
 	for _, u := range users {
+		if !u.CanUseNode(models.LinkNodeID(peer.ID)) {
+			continue
+		}
 		uuids = append(uuids, u.UUID)
 	}
 
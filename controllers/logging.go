@@ -0,0 +1,44 @@
+package controllers
+
+import (
+	"freegfw/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetLogConfig(c *gin.Context) {
+	c.JSON(http.StatusOK, services.LoadLogConfig())
+}
+
+// UpdateLogConfig applies the panel level immediately and restarts the
+// running engine when one of the engine levels changed.
+func UpdateLogConfig(c *gin.Context) {
+	before := services.LoadLogConfig()
+	payload := before
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := payload.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := services.SaveLogConfig(payload); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	recordAudit(c, "UpdateLogConfig", "", before, payload)
+
+	if payload.SingboxLevel != before.SingboxLevel || payload.XrayLevel != before.XrayLevel {
+		core := services.NewCoreService()
+		if core.IsRunning() {
+			if err := core.Refresh(); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			core.Start()
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
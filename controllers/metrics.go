@@ -0,0 +1,15 @@
+package controllers
+
+import (
+	"bytes"
+	"freegfw/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetMetrics(c *gin.Context) {
+	var buf bytes.Buffer
+	services.WriteMetrics(&buf)
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", buf.Bytes())
+}
@@ -0,0 +1,55 @@
+package controllers
+
+import (
+	"freegfw/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetNotificationConfig(c *gin.Context) {
+	c.JSON(http.StatusOK, services.LoadNotificationConfig().Masked())
+}
+
+func UpdateNotificationConfig(c *gin.Context) {
+	before := services.LoadNotificationConfig()
+	var payload services.NotificationConfig
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	payload.KeepSecrets(before)
+	if err := services.SaveNotificationConfig(payload); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	recordAudit(c, "UpdateNotificationConfig", "", before, payload)
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// TestNotification sends a test message through every enabled channel. The
+// request body may carry an unsaved configuration; otherwise the stored one
+// is used.
+func TestNotification(c *gin.Context) {
+	stored := services.LoadNotificationConfig()
+	cfg := stored
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&cfg); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		cfg.KeepSecrets(stored)
+	}
+
+	results := gin.H{}
+	success := true
+	for name, err := range services.SendTestNotification(cfg) {
+		if err != nil {
+			results[name] = err.Error()
+			success = false
+		} else {
+			results[name] = "ok"
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"success": success, "results": results})
+}
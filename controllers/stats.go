@@ -0,0 +1,37 @@
+package controllers
+
+import (
+	"freegfw/services"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetDestinationStats returns the top destinations by traffic.
+// Query: window (Go duration, default 1h, max 24h), limit (default 20), user.
+func GetDestinationStats(c *gin.Context) {
+	window := time.Hour
+	if w := c.Query("window"); w != "" {
+		d, err := time.ParseDuration(w)
+		if err != nil || d <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid window"})
+			return
+		}
+		window = d
+	}
+	if window > 24*time.Hour {
+		window = 24 * time.Hour
+	}
+
+	limit := 20
+	if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 {
+		limit = l
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"window": window.String(),
+		"items":  services.TopDestinations(window, c.Query("user"), limit),
+	})
+}
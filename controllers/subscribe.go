@@ -0,0 +1,373 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"freegfw/database"
+	"freegfw/models"
+	"freegfw/services"
+	"freegfw/utils"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// subscription is what a renderer turns into one client format.
+type subscription struct {
+	Context *gin.Context
+	User    models.User
+	Nodes   []utils.Node
+}
+
+// subscriptionRenderer produces one subscription format.
+type subscriptionRenderer struct {
+	ContentType string
+	Render      func(sub *subscription) ([]byte, error)
+}
+
+var subscriptionRenderers = map[string]*subscriptionRenderer{}
+
+// registerSubscriptionFormat makes a renderer available under the given
+// ?format= / ?target= names. The first name is the canonical one.
+func registerSubscriptionFormat(r *subscriptionRenderer, names ...string) {
+	for _, name := range names {
+		subscriptionRenderers[name] = r
+	}
+}
+
+// subscriptionUserAgents picks a format for clients that do not ask for one.
+// It is only a fallback; ?format= or ?target= always take precedence.
+var subscriptionUserAgents = []struct {
+	Substring string
+	Format    string
+}{
+	{"mihomo", "mihomo"},
+	{"clash", "clash"},
+	{"surge", "surge"},
+	{"quantumult", "quantumultx"},
+	{"loon", "loon"},
+}
+
+// subscriptionAccess is the user a subscription request resolved to and
+// how the subscription was addressed.
+type subscriptionAccess struct {
+	User models.User
+	// Path is /subscribe/<uuid> or, for signed links, /subscribe/<token>
+	Path string
+	// Query is the signature query of signed links, "" otherwise
+	Query string
+	// Expires is when a signed link stops working, zero otherwise
+	Expires time.Time
+}
+
+// url returns the subscription path with suffix appended, keeping the
+// signature of signed links.
+func (a subscriptionAccess) url(suffix string) string {
+	if a.Query == "" {
+		return a.Path + suffix
+	}
+	return a.Path + suffix + "?" + a.Query
+}
+
+// resolveSubscription finds the user of a subscription request, by UUID or,
+// when ?exp= and ?sig= are present, by a signed token. On failure it writes
+// the response and returns false.
+func resolveSubscription(c *gin.Context) (subscriptionAccess, bool) {
+	param := c.Param("uuid")
+	access := subscriptionAccess{Path: "/subscribe/" + param}
+
+	if sig := c.Query("sig"); sig != "" || c.Query("exp") != "" {
+		userID, signed, err := services.VerifySubscription(param, c.Query("exp"), sig)
+		switch {
+		case errors.Is(err, services.ErrSubscriptionExpired):
+			c.String(http.StatusGone, err.Error())
+			return access, false
+		case err != nil:
+			c.String(http.StatusForbidden, "")
+			return access, false
+		}
+		if err := database.DB.First(&access.User, userID).Error; err != nil {
+			c.String(http.StatusNotFound, "")
+			return access, false
+		}
+		access.Query = signed.Query()
+		access.Expires = signed.Expires
+		return access, true
+	}
+
+	if err := database.DB.Where("uuid = ?", param).First(&access.User).Error; err != nil {
+		c.String(http.StatusNotFound, "")
+		return access, false
+	}
+	return access, true
+}
+
+func GetSubscribe(c *gin.Context) {
+	access, ok := resolveSubscription(c)
+	if !ok {
+		return
+	}
+	user := access.User
+
+	format := strings.ToLower(c.Query("format"))
+	if format == "" {
+		format = strings.ToLower(c.Query("target"))
+	}
+
+	if format == "" {
+		ua := strings.ToLower(c.GetHeader("User-Agent"))
+		for _, m := range subscriptionUserAgents {
+			if strings.Contains(ua, m.Substring) {
+				format = m.Format
+				break
+			}
+		}
+	}
+
+	isBrowser := false
+	if format == "" {
+		// Browser detection
+		ua := strings.ToLower(c.GetHeader("User-Agent"))
+		isBrowser = strings.Contains(ua, "mozilla") &&
+			!strings.Contains(ua, "shadowrocket") &&
+			!strings.Contains(ua, "hiddify") &&
+			!strings.Contains(ua, "stash")
+		if isBrowser {
+			format = "html"
+		} else {
+			format = "base64"
+		}
+	}
+
+	// Rejected fetches are not stored, so a client retrying in a loop
+	// cannot flood the log
+	if !services.AllowSubscriptionFetch(user.ID) {
+		c.String(http.StatusTooManyRequests, "")
+		return
+	}
+	defer func() {
+		services.RecordSubscriptionFetch(user, c.ClientIP(), c.GetHeader("User-Agent"), format, c.Writer.Status())
+	}()
+
+	if isBrowser {
+		renderLandingPage(c, access)
+		return
+	}
+
+	renderer, ok := subscriptionRenderers[format]
+	if !ok {
+		c.String(http.StatusBadRequest, "unknown format %q, supported: %s", format, strings.Join(subscriptionFormatNames(), ", "))
+		return
+	}
+
+	sub := &subscription{Context: c, User: user, Nodes: subscriptionNodes(c, user)}
+	body, err := renderer.Render(sub)
+	if err != nil {
+		slog.Error("Failed to render subscription", "format", format, "err", err)
+		c.String(http.StatusInternalServerError, "")
+		return
+	}
+	setSubscriptionHeaders(c, user, access.Expires)
+	c.Data(http.StatusOK, renderer.ContentType, body)
+}
+
+// subscriptionUpdateInterval is the refresh interval, in hours, suggested to
+// clients through the Profile-Update-Interval header.
+const subscriptionUpdateInterval = 12
+
+// setSubscriptionHeaders adds the de-facto profile headers that Clash,
+// sing-box, Shadowrocket and others use to show the profile name, used
+// traffic and quota.
+func setSubscriptionHeaders(c *gin.Context, user models.User, expires time.Time) {
+	// Users have no expiry, so expire is only sent for signed links; clients
+	// read its absence as "never".
+	userinfo := fmt.Sprintf("upload=%d; download=%d; total=%d", user.Upload, user.Download, user.Quota)
+	if !expires.IsZero() {
+		userinfo += fmt.Sprintf("; expire=%d", expires.Unix())
+	}
+	c.Header("Subscription-Userinfo", userinfo)
+	c.Header("Profile-Update-Interval", strconv.Itoa(subscriptionUpdateInterval))
+
+	title := siteTitle()
+	c.Header("Profile-Title", "base64:"+base64.StdEncoding.EncodeToString([]byte(title)))
+	c.Header("Content-Disposition", "attachment; filename*=UTF-8''"+url.PathEscape(title))
+}
+
+// siteTitle returns the configured node title.
+func siteTitle() string {
+	var tS models.Setting
+	database.DB.Where("key = ?", "title").Limit(1).Find(&tS)
+	title := "FreeGFW"
+	if len(tS.Value) > 0 {
+		json.Unmarshal(tS.Value, &title)
+	}
+	return title
+}
+
+func subscriptionFormatNames() []string {
+	names := make([]string, 0, len(subscriptionRenderers))
+	for name := range subscriptionRenderers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// subscriptionBaseURL returns the scheme and host the request was made to.
+func subscriptionBaseURL(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host
+}
+
+// withQuery appends key=value to u, which may already have a query.
+func withQuery(u, key, value string) string {
+	sep := "?"
+	if strings.Contains(u, "?") {
+		sep = "&"
+	}
+	return u + sep + key + "=" + url.QueryEscape(value)
+}
+
+// subscriptionURL returns the absolute URL the subscription was requested
+// with.
+func subscriptionURL(c *gin.Context) string {
+	return subscriptionBaseURL(c) + c.Request.RequestURI
+}
+
+// subscriptionNodes returns the local node followed by every successfully
+// synced remote node, limited to the nodes the user may use.
+func subscriptionNodes(c *gin.Context, user models.User) []utils.Node {
+	var nodes []utils.Node
+
+	var s models.Setting
+	database.DB.Where("key = ?", "server").Limit(1).Find(&s)
+	// If local server is not configured, we might still have remote links, but proceeding with caution.
+	var localServer map[string]interface{}
+	// Try unmarshal directly or as stringified json
+	if len(s.Value) > 0 {
+		if err := json.Unmarshal(s.Value, &localServer); err != nil {
+			var str string
+			if err2 := json.Unmarshal(s.Value, &str); err2 == nil {
+				json.Unmarshal([]byte(str), &localServer)
+			}
+		}
+	}
+
+	var ipS models.Setting
+	database.DB.Where("key = ?", "ip").Limit(1).Find(&ipS)
+	var localIP string
+	json.Unmarshal(ipS.Value, &localIP)
+
+	var tS models.Setting
+	database.DB.Where("key = ?", "title").Limit(1).Find(&tS)
+	title := "FreeGFW"
+	if len(tS.Value) > 0 {
+		json.Unmarshal(tS.Value, &title)
+	}
+
+	if localIP == "" {
+		// Fallback to request host if IP is not set
+		host := c.Request.Host
+		if strings.Contains(host, ":") {
+			host = strings.Split(host, ":")[0]
+		}
+		localIP = host
+	}
+
+	// Add local node if configured
+	if localServer != nil && user.CanUseNode(models.LocalNodeID) {
+		fillRealityPublicKey(localServer)
+		node := utils.Node{
+			Title:  title,
+			IP:     localIP,
+			Port:   serverPort(localServer),
+			Server: localServer,
+		}
+		var t models.Setting
+		database.DB.Where("key = ?", "template").Limit(1).Find(&t)
+		templateName := string(t.Value)
+		json.Unmarshal(t.Value, &templateName)
+		if tmpl, err := services.LoadTemplate(templateName); err == nil {
+			node.Client = tmpl.Client
+		}
+		nodes = append(nodes, node)
+	}
+
+	// Fetch remote links
+	var remoteLinks []models.Link
+	// We only care about links that have successfully synced
+	database.DB.Where("last_sync_status = ?", "success").Find(&remoteLinks)
+
+	for _, rl := range remoteLinks {
+		if !user.CanUseNode(models.LinkNodeID(rl.ID)) {
+			continue
+		}
+		var remoteServer map[string]interface{}
+		if err := json.Unmarshal(rl.Server, &remoteServer); err == nil && remoteServer != nil {
+			ip := ""
+			if rl.IP != nil {
+				ip = *rl.IP
+			}
+
+			itemTitle := ""
+			if t, ok := remoteServer["title"].(string); ok && t != "" {
+				itemTitle = t
+			} else {
+				itemTitle = title
+				if ip != "" && ip != localIP {
+					itemTitle = fmt.Sprintf("%s (%s)", title, ip)
+				}
+			}
+
+			nodes = append(nodes, utils.Node{
+				Title:  itemTitle,
+				IP:     ip,
+				Port:   serverPort(remoteServer),
+				Server: remoteServer,
+			})
+		}
+	}
+	utils.UniqueTitles(nodes)
+	return nodes
+}
+
+func serverPort(server map[string]interface{}) string {
+	portVal := server["listen_port"]
+	// Handles float64 from json
+	if f, ok := portVal.(float64); ok {
+		return fmt.Sprintf("%d", int(f))
+	}
+	return fmt.Sprintf("%v", portVal)
+}
+
+// fillRealityPublicKey falls back to the stored public key when the local
+// REALITY config does not carry one.
+func fillRealityPublicKey(server map[string]interface{}) {
+	tlsConfig, _ := server["tls"].(map[string]interface{})
+	if tlsConfig == nil {
+		return
+	}
+	reality, _ := tlsConfig["reality"].(map[string]interface{})
+	if reality == nil || reality["enabled"] != true {
+		return
+	}
+	if pk, _ := reality["public_key"].(string); pk != "" {
+		return
+	}
+	var pkS models.Setting
+	database.DB.Where("key = ?", "reality_public_key").Limit(1).Find(&pkS)
+	var pub string
+	json.Unmarshal(pkS.Value, &pub)
+	reality["public_key"] = pub
+}
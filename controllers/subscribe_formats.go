@@ -0,0 +1,157 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"freegfw/sharelink"
+	"freegfw/utils"
+	"log/slog"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+func init() {
+	registerSubscriptionFormat(&subscriptionRenderer{
+		ContentType: "text/plain; charset=utf-8",
+		Render:      renderBase64,
+	}, "base64", "v2ray", "mixed")
+	registerSubscriptionFormat(&subscriptionRenderer{
+		ContentType: "text/yaml; charset=utf-8",
+		Render:      renderClash,
+	}, "clash", "mihomo", "meta", "clash.meta")
+	registerSubscriptionFormat(&subscriptionRenderer{
+		ContentType: "application/json; charset=utf-8",
+		Render:      renderSingbox,
+	}, "sing-box", "singbox")
+	registerSubscriptionFormat(&subscriptionRenderer{
+		ContentType: "application/json; charset=utf-8",
+		Render:      renderXray,
+	}, "xray")
+	registerSubscriptionFormat(&subscriptionRenderer{
+		ContentType: "text/plain; charset=utf-8",
+		Render:      renderSurge,
+	}, "surge")
+	registerSubscriptionFormat(&subscriptionRenderer{
+		ContentType: "text/plain; charset=utf-8",
+		Render:      renderQuantumultX,
+	}, "quantumultx", "quanx", "qx")
+	registerSubscriptionFormat(&subscriptionRenderer{
+		ContentType: "text/plain; charset=utf-8",
+		Render:      renderLoon,
+	}, "loon")
+	registerSubscriptionFormat(&subscriptionRenderer{
+		ContentType: "application/json; charset=utf-8",
+		Render:      renderJSON,
+	}, "json")
+}
+
+func renderBase64(sub *subscription) ([]byte, error) {
+	var links []string
+	for _, n := range sub.Nodes {
+		if l := sharelink.Generate(n, sub.User.UUID, sub.User.Username); l != "" {
+			links = append(links, l)
+		}
+	}
+	return []byte(base64.StdEncoding.EncodeToString([]byte(strings.Join(links, "\n")))), nil
+}
+
+func renderClash(sub *subscription) ([]byte, error) {
+	var proxies []map[string]interface{}
+	for _, n := range sub.Nodes {
+		if p := utils.ToClashProxy(n, sub.User.UUID, sub.User.Username); p != nil {
+			proxies = append(proxies, p)
+		}
+	}
+	if tmpl := textSetting("clash_template"); strings.TrimSpace(tmpl) != "" {
+		cfg, err := utils.RenderClashTemplate(tmpl, proxies)
+		if err == nil {
+			return yaml.Marshal(cfg)
+		}
+		slog.Error("Invalid Clash template, using the built-in profile", "err", err)
+	}
+	return yaml.Marshal(utils.GenClashConfig(proxies))
+}
+
+func renderSingbox(sub *subscription) ([]byte, error) {
+	var outbounds []map[string]interface{}
+	for _, n := range sub.Nodes {
+		if o := utils.ToSingboxOutbound(n, sub.User.UUID, sub.User.Username); o != nil {
+			outbounds = append(outbounds, o)
+		}
+	}
+	return json.MarshalIndent(utils.GenSingboxConfig(outbounds), "", "  ")
+}
+
+func renderXray(sub *subscription) ([]byte, error) {
+	var outbounds []map[string]interface{}
+	for _, n := range sub.Nodes {
+		if o := utils.ToXrayOutbound(n, sub.User.UUID); o != nil {
+			outbounds = append(outbounds, o)
+		}
+	}
+	return json.MarshalIndent(utils.GenXrayConfig(outbounds), "", "  ")
+}
+
+func renderSurge(sub *subscription) ([]byte, error) {
+	var proxies []string
+	for _, n := range utils.INITitles(sub.Nodes) {
+		if p := utils.ToSurgeProxy(n, sub.User.UUID); p != "" {
+			proxies = append(proxies, p)
+		}
+	}
+	return []byte(utils.GenSurgeConfig(proxies, subscriptionURL(sub.Context))), nil
+}
+
+// renderQuantumultX lists the nodes as a Quantumult X server resource, to be
+// added under server_remote.
+func renderQuantumultX(sub *subscription) ([]byte, error) {
+	var lines []string
+	for _, n := range utils.INITitles(sub.Nodes) {
+		if l := utils.ToQuantumultXServer(n, sub.User.UUID); l != "" {
+			lines = append(lines, l)
+		}
+	}
+	return []byte(strings.Join(lines, "\n")), nil
+}
+
+func renderLoon(sub *subscription) ([]byte, error) {
+	var proxies []string
+	for _, n := range utils.INITitles(sub.Nodes) {
+		if p := utils.ToLoonProxy(n, sub.User.UUID); p != "" {
+			proxies = append(proxies, p)
+		}
+	}
+	return []byte(utils.GenLoonConfig(proxies)), nil
+}
+
+// renderJSON lists the nodes in a neutral form for scripts and dashboards.
+func renderJSON(sub *subscription) ([]byte, error) {
+	type jsonNode struct {
+		Title    string                 `json:"title"`
+		Type     string                 `json:"type"`
+		Server   string                 `json:"server"`
+		Port     int                    `json:"port"`
+		Link     string                 `json:"link,omitempty"`
+		Outbound map[string]interface{} `json:"outbound,omitempty"`
+	}
+	list := make([]jsonNode, 0, len(sub.Nodes))
+	for _, n := range sub.Nodes {
+		serverType, _ := n.Server["type"].(string)
+		list = append(list, jsonNode{
+			Title:    n.Title,
+			Type:     serverType,
+			Server:   n.IP,
+			Port:     n.PortNumber(),
+			Link:     sharelink.Generate(n, sub.User.UUID, sub.User.Username),
+			Outbound: utils.ToSingboxOutbound(n, sub.User.UUID, sub.User.Username),
+		})
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(list)
+	return buf.Bytes(), err
+}
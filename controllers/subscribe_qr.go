@@ -0,0 +1,82 @@
+package controllers
+
+import (
+	"freegfw/services"
+	"freegfw/sharelink"
+	"freegfw/utils"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetSubscribeQR renders a QR code of the subscription URL or, with
+// ?node=<index>, of the share link of one node of the subscription.
+// Query: type (png or svg, default png), size (PNG pixels, default 256),
+// format (subscription format to put in the URL).
+func GetSubscribeQR(c *gin.Context) {
+	access, ok := resolveSubscription(c)
+	if !ok {
+		return
+	}
+	user := access.User
+	node := c.Query("node")
+
+	// A QR code carries the same credentials as the subscription, so it is
+	// rate limited and logged the same way
+	format := "qr"
+	if node != "" {
+		format = "qr-node"
+	}
+	// Rejected fetches are not stored, so a client retrying in a loop
+	// cannot flood the log
+	if !services.AllowSubscriptionFetch(user.ID) {
+		c.String(http.StatusTooManyRequests, "")
+		return
+	}
+	defer func() {
+		services.RecordSubscriptionFetch(user, c.ClientIP(), c.GetHeader("User-Agent"), format, c.Writer.Status())
+	}()
+
+	var content string
+	if node != "" {
+		i, err := strconv.Atoi(node)
+		nodes := subscriptionNodes(c, user)
+		if err != nil || i < 0 || i >= len(nodes) {
+			c.String(http.StatusNotFound, "")
+			return
+		}
+		content = sharelink.Generate(nodes[i], user.UUID, user.Username)
+		if content == "" {
+			c.String(http.StatusNotFound, "")
+			return
+		}
+	} else {
+		content = subscriptionBaseURL(c) + access.url("")
+		if format := c.Query("format"); format != "" {
+			content = withQuery(content, "format", format)
+		}
+	}
+
+	if c.DefaultQuery("type", "png") == "svg" {
+		svg, err := utils.QRSVG(content)
+		if err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		c.Data(http.StatusOK, "image/svg+xml", []byte(svg))
+		return
+	}
+
+	size, _ := strconv.Atoi(c.DefaultQuery("size", "256"))
+	if size < 64 || size > 2048 {
+		c.String(http.StatusBadRequest, "size must be between 64 and 2048")
+		return
+	}
+	png, err := utils.QRPNG(content, size)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.Data(http.StatusOK, "image/png", png)
+}
@@ -0,0 +1,99 @@
+package controllers
+
+import (
+	"freegfw/database"
+	"freegfw/models"
+	"freegfw/services"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetSubscriptionConfig(c *gin.Context) {
+	c.JSON(http.StatusOK, services.LoadSubscriptionConfig())
+}
+
+func UpdateSubscriptionConfig(c *gin.Context) {
+	before := services.LoadSubscriptionConfig()
+	payload := before
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := services.SaveSubscriptionConfig(payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	recordAudit(c, "UpdateSubscriptionConfig", "", before, payload)
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// GetSubscriptionStats returns per-user fetch statistics.
+// Query: hours to look back (default 24).
+func GetSubscriptionStats(c *gin.Context) {
+	hours, _ := strconv.Atoi(c.DefaultQuery("hours", "24"))
+	if hours < 1 {
+		hours = 24
+	}
+	stats, err := services.GetSubscriptionStats(time.Now().Add(-time.Duration(hours) * time.Hour))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if stats == nil {
+		stats = []services.SubscriptionUserStats{}
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
+// GetSubscriptionFetches returns recorded subscription fetches newest first.
+// Query: user (ID), from and to (RFC 3339 or unix seconds), page (default 1),
+// pageSize (default 50, max 500).
+func GetSubscriptionFetches(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if page < 1 {
+		page = 1
+	}
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "50"))
+	if pageSize < 1 {
+		pageSize = 50
+	}
+	if pageSize > 500 {
+		pageSize = 500
+	}
+
+	query := database.DB.Model(&models.SubscriptionFetch{})
+	if user := c.Query("user"); user != "" {
+		query = query.Where("user_id = ?", user)
+	}
+	for _, bound := range []struct{ param, cond string }{
+		{"from", "created_at >= ?"},
+		{"to", "created_at <= ?"},
+	} {
+		v := c.Query(bound.param)
+		if v == "" {
+			continue
+		}
+		t, err := parseTimeParam(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + bound.param})
+			return
+		}
+		query = query.Where(bound.cond, t)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var fetches []models.SubscriptionFetch
+	query.Order("created_at DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&fetches)
+
+	c.JSON(http.StatusOK, gin.H{
+		"items":    fetches,
+		"total":    total,
+		"page":     page,
+		"pageSize": pageSize,
+	})
+}
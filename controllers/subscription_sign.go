@@ -0,0 +1,74 @@
+package controllers
+
+import (
+	"freegfw/database"
+	"freegfw/models"
+	"freegfw/services"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CreateSignedSubscription issues a subscription link for the user that
+// stops working at expiresAt (RFC 3339 or unix seconds) or after the given
+// number of hours.
+func CreateSignedSubscription(c *gin.Context) {
+	id := c.Param("id")
+	var payload struct {
+		ExpiresAt string `json:"expiresAt"`
+		Hours     int    `json:"hours"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var expires time.Time
+	switch {
+	case payload.ExpiresAt != "":
+		t, err := parseTimeParam(payload.ExpiresAt)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid expiresAt"})
+			return
+		}
+		expires = t
+	case payload.Hours > 0:
+		expires = time.Now().Add(time.Duration(payload.Hours) * time.Hour)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expiresAt or hours is required"})
+		return
+	}
+	if !expires.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expiry must be in the future"})
+		return
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	signed, err := services.SignSubscription(user.ID, expires)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	recordAudit(c, "CreateSignedSubscription", id, nil, gin.H{"expires": signed.Expires})
+	c.JSON(http.StatusOK, gin.H{
+		"url":     subscriptionBaseURL(c) + signed.Path(),
+		"path":    signed.Path(),
+		"expires": signed.Expires,
+	})
+}
+
+// RotateSubscriptionSecret revokes every signed subscription link.
+func RotateSubscriptionSecret(c *gin.Context) {
+	if err := services.RotateSubscriptionSecret(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	recordAudit(c, "RotateSubscriptionSecret", "", nil, nil)
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
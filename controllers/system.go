@@ -0,0 +1,32 @@
+package controllers
+
+import (
+	"freegfw/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetSystemStats returns the latest host and process sample.
+func GetSystemStats(c *gin.Context) {
+	c.JSON(http.StatusOK, services.CurrentSystemStats())
+}
+
+func GetWatchdogConfig(c *gin.Context) {
+	c.JSON(http.StatusOK, services.LoadWatchdogConfig())
+}
+
+func UpdateWatchdogConfig(c *gin.Context) {
+	before := services.LoadWatchdogConfig()
+	payload := before
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := services.SaveWatchdogConfig(payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	recordAudit(c, "UpdateWatchdogConfig", "", before, payload)
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
@@ -55,7 +55,9 @@ func CreateTemplate(c *gin.Context) {
 		return
 	}
 
-	doInit(c, templateName)
+	if doInit(c, templateName) {
+		recordAudit(c, "CreateTemplate", templateName, nil, gin.H{"slug": newTemplate.Slug, "name": newTemplate.Name, "content": template})
+	}
 }
 
 func InitTemplate(c *gin.Context) {
@@ -66,21 +68,26 @@ func InitTemplate(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	doInit(c, payload.Type)
+	before := settingsSnapshot("template")
+	if doInit(c, payload.Type) {
+		recordAudit(c, "InitTemplate", payload.Type, before, settingsSnapshot("template"))
+	}
 }
 
-func doInit(c *gin.Context, typeName string) {
+// doInit installs the template unless the node is already set up and writes
+// the response. It reports whether the request succeeded.
+func doInit(c *gin.Context, typeName string) bool {
 	var count int64
 	database.DB.Model(&models.Setting{}).Where("key = ?", "server").Count(&count)
 	if count > 0 {
 		c.JSON(http.StatusOK, gin.H{"success": true})
-		return
+		return true
 	}
 
 	if err := services.InitTemplate(typeName); err != nil {
 		database.DB.Exec("DELETE FROM settings")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+		return false
 	}
 
 	core := services.NewCoreService()
@@ -88,4 +95,5 @@ func doInit(c *gin.Context, typeName string) {
 	core.Start()
 
 	c.JSON(http.StatusOK, gin.H{"success": true})
+	return true
 }
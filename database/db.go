@@ -54,8 +54,21 @@ func Connect(path string) {
 	sqlDB.SetConnMaxLifetime(time.Hour)   // Connection maximum lifetime 1 hour
 	sqlDB.SetConnMaxIdleTime(time.Minute) // Release connection if idle for more than 1 minute
 
-	err = DB.AutoMigrate(&models.User{}, &models.Link{}, &models.Setting{}, &models.Template{})
+	err = DB.AutoMigrate(&models.User{}, &models.Link{}, &models.Setting{}, &models.Template{}, &models.AuditLog{}, &models.AccessLog{}, &models.SubscriptionFetch{})
 	if err != nil {
 		log.Fatal("Failed to migrate database:", err)
 	}
 }
+
+// Close checkpoints the WAL into the main database file and closes the
+// connection. It is called once on shutdown.
+func Close() error {
+	if err := DB.Exec("PRAGMA wal_checkpoint(TRUNCATE)").Error; err != nil {
+		log.Println("Failed to checkpoint WAL:", err)
+	}
+	sqlDB, err := DB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
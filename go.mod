@@ -9,6 +9,7 @@ require (
 	github.com/googollee/go-socket.io v1.7.0
 	github.com/sagernet/sing v0.8.4
 	github.com/sagernet/sing-box v1.13.0-rc.3
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
 	github.com/xtls/xray-core v1.251015.0
 	golang.org/x/crypto v0.49.0
 	golang.org/x/time v0.15.0
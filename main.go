@@ -8,7 +8,7 @@ import (
 	"freegfw/routes"
 	"freegfw/services"
 	"io/fs"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
@@ -23,6 +23,7 @@ func main() {
 	os.MkdirAll("data", 0755)
 
 	database.Connect("data/freegfw.db")
+	services.InitLogging()
 	services.MigrateTemplates()
 
 	services.InitSSEHub()
@@ -71,33 +72,34 @@ func main() {
 		go func() {
 			var err error
 			if hasCert {
-				log.Println("Starting HTTPS server on port " + port)
+				slog.Info("Starting HTTPS server", "port", port)
 				err = srv.ListenAndServeTLS(certFile, keyFile)
 			} else {
-				log.Println("Starting HTTP server on port " + port)
+				slog.Info("Starting HTTP server", "port", port)
 				err = srv.ListenAndServe()
 			}
 			if err != nil && err != http.ErrServerClosed {
-				log.Printf("Server error: %s\n", err)
+				slog.Error("Server error", "err", err)
 			}
 		}()
 
 		select {
 		case <-quit:
-			log.Println("Shutting down server...")
+			slog.Info("Shutting down server")
 			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 			defer cancel()
 			if err := srv.Shutdown(ctx); err != nil {
-				log.Fatal("Server forced to shutdown:", err)
+				slog.Error("Server forced to shutdown", "err", err)
 			}
+			services.Shutdown()
 			return
 
 		case <-services.RestartChan:
-			log.Println("Restart signal received. Restarting server...")
+			slog.Info("Restart signal received, restarting server")
 			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 			defer cancel()
 			if err := srv.Shutdown(ctx); err != nil {
-				log.Println("Server shutdown error:", err)
+				slog.Error("Server shutdown error", "err", err)
 			}
 
 			core := services.NewCoreService()
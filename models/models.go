@@ -4,6 +4,7 @@ import (
 	"database/sql/driver"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"time"
 )
 
@@ -50,10 +51,33 @@ type User struct {
 	Upload     int64     `json:"upload" gorm:"default:0"`
 	Download   int64     `json:"download" gorm:"default:0"`
 	SpeedLimit uint64    `json:"speedLimit" gorm:"default:0"`
+	Quota      int64     `json:"quota" gorm:"default:0"`                 // Traffic quota in bytes, 0 means unlimited
+	Nodes      []string  `json:"nodes" gorm:"serializer:json;type:text"` // Node IDs the user may see and use, empty means all
 	CreatedAt  time.Time `json:"createdAt"`
 	UpdatedAt  time.Time `json:"updatedAt"`
 }
 
+// LocalNodeID is the node ID of this server's own inbound.
+const LocalNodeID = "local"
+
+// LinkNodeID returns the node ID of a linked peer.
+func LinkNodeID(linkID uint) string {
+	return fmt.Sprintf("link:%d", linkID)
+}
+
+// CanUseNode reports whether the user may see and connect to the node.
+func (u User) CanUseNode(nodeID string) bool {
+	if len(u.Nodes) == 0 {
+		return true
+	}
+	for _, id := range u.Nodes {
+		if id == nodeID {
+			return true
+		}
+	}
+	return false
+}
+
 type Link struct {
 	ID             uint      `gorm:"primaryKey" json:"id"`
 	LocalCode      string    `json:"localCode"`
@@ -66,6 +90,7 @@ type Link struct {
 	Name           *string   `json:"name"`
 	Error          *string   `json:"error"`
 	ETag           *string   `json:"eTag"`
+	PeerKey        string    `json:"peerKey"` // pinned ed25519 identity key of the peer
 	CreatedAt      time.Time `json:"createdAt"`
 	UpdatedAt      time.Time `json:"updatedAt"`
 }
@@ -87,3 +112,43 @@ type Template struct {
 	CreatedAt   time.Time `json:"createdAt"`
 	UpdatedAt   time.Time `json:"updatedAt"`
 }
+
+type AuditLog struct {
+	ID        uint            `gorm:"primaryKey" json:"id"`
+	Actor     string          `json:"actor"`
+	IP        string          `json:"ip"`
+	Method    string          `json:"method"`
+	Route     string          `json:"route"`
+	Action    string          `json:"action" gorm:"index"`
+	TargetID  string          `json:"targetId"`
+	Diff      json.RawMessage `gorm:"type:text" json:"diff"`
+	CreatedAt time.Time       `json:"createdAt" gorm:"index"`
+}
+
+// AccessLog is one finished proxied connection, recorded only when the
+// access log is enabled.
+type AccessLog struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	User        string    `json:"user" gorm:"index"`
+	Engine      string    `json:"engine"`
+	Protocol    string    `json:"protocol"`
+	Network     string    `json:"network"`
+	Source      string    `json:"source"`
+	Destination string    `json:"destination"`
+	Upload      int64     `json:"upload"`
+	Download    int64     `json:"download"`
+	Duration    int64     `json:"duration"` // milliseconds
+	Outcome     string    `json:"outcome"`
+	StartedAt   time.Time `json:"startedAt" gorm:"index"`
+}
+
+// SubscriptionFetch is one request for a user's subscription.
+type SubscriptionFetch struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `json:"userId" gorm:"index"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"userAgent"`
+	Format    string    `json:"format"`
+	Status    int       `json:"status"` // HTTP status of the response
+	CreatedAt time.Time `json:"createdAt" gorm:"index"`
+}
@@ -92,23 +92,58 @@ func SetupRouter(staticFS fs.FS) *gin.Engine {
 		api.PUT("/users/:id", controllers.UpdateUser)
 		api.GET("/users", controllers.GetUsers)
 		api.DELETE("/users/:id", controllers.DeleteUser)
+		api.POST("/users/:id/subscription-link", controllers.CreateSignedSubscription)
 
 		api.POST("/letsencrypt/init", controllers.InitLetsEncrypt)
 
 		api.POST("/link/create", controllers.CreateLink)
 		api.POST("/link/swap", controllers.SwapLink)
 		api.GET("/link/list", controllers.ListLinks)
+		api.GET("/link/key", controllers.GetLinkKey)
+		api.PUT("/link/:id/key", controllers.SetLinkKey)
 		api.DELETE("/link/:id", controllers.DeleteLink)
+
+		api.GET("/metrics", controllers.GetMetrics)
+		api.GET("/stats/destinations", controllers.GetDestinationStats)
+		api.GET("/stats/system", controllers.GetSystemStats)
+
+		api.GET("/watchdog", controllers.GetWatchdogConfig)
+		api.PUT("/watchdog", controllers.UpdateWatchdogConfig)
+
+		api.GET("/notifications", controllers.GetNotificationConfig)
+		api.PUT("/notifications", controllers.UpdateNotificationConfig)
+		api.POST("/notifications/test", controllers.TestNotification)
+
+		api.GET("/audit", controllers.GetAuditLogs)
+
+		api.GET("/logging", controllers.GetLogConfig)
+		api.PUT("/logging", controllers.UpdateLogConfig)
+
+		api.GET("/access-logs", controllers.GetAccessLogs)
+		api.GET("/access-logs/config", controllers.GetAccessLogConfig)
+		api.PUT("/access-logs/config", controllers.UpdateAccessLogConfig)
+		api.GET("/subscriptions/stats", controllers.GetSubscriptionStats)
+		api.GET("/subscriptions/fetches", controllers.GetSubscriptionFetches)
+		api.GET("/subscriptions/config", controllers.GetSubscriptionConfig)
+		api.PUT("/subscriptions/config", controllers.UpdateSubscriptionConfig)
+		api.POST("/subscriptions/secret/rotate", controllers.RotateSubscriptionSecret)
+		api.GET("/landing-page", controllers.GetLandingPage)
+		api.PUT("/landing-page", controllers.UpdateLandingPage)
+		api.GET("/clash-template", controllers.GetClashTemplate)
+		api.PUT("/clash-template", controllers.UpdateClashTemplate)
 	}
 
 	r.POST("/link/:code", controllers.BindLink)
+	r.POST("/link/:code/notify", controllers.NotifyLink)
 	r.GET("/subscribe/:uuid", controllers.GetSubscribe)
+	r.GET("/subscribe/:uuid/qr", controllers.GetSubscribeQR)
 
 	// Authorized group for frontend and internal operations
 	authorized := r.Group("/")
 	authorized.Use(AuthMiddleware())
 	{
 		authorized.GET("/stream/traffic", gin.WrapF(services.ServeSSE))
+		authorized.GET("/stream/logs", gin.WrapF(services.ServeLogSSE))
 
 		// Static file serving from embedded FS
 		authorized.StaticFileFS("/favicon.ico", "favicon.ico", http.FS(staticFS))
@@ -0,0 +1,175 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"freegfw/database"
+	"freegfw/models"
+)
+
+const (
+	// accessLogBatch is the most rows written per insert.
+	accessLogBatch = 500
+	// accessLogFlushInterval bounds how long a finished connection waits in memory.
+	accessLogFlushInterval = 5 * time.Second
+)
+
+// AccessLogConfig is stored as JSON in the "access_log" setting.
+type AccessLogConfig struct {
+	Enabled bool `json:"enabled"`
+	// RetentionDays is how long rows are kept; 0 keeps them forever.
+	RetentionDays int `json:"retention_days"`
+}
+
+// accessLogger persists finished connections in batches so the connection
+// close path never waits on SQLite.
+type accessLogger struct {
+	enabled   atomic.Bool
+	retention atomic.Int64 // days
+	queue     chan *models.AccessLog
+	flushReq  chan chan struct{}
+	dropped   atomic.Int64
+}
+
+var accessLog = &accessLogger{
+	queue:    make(chan *models.AccessLog, 4096),
+	flushReq: make(chan chan struct{}),
+}
+
+func LoadAccessLogConfig() AccessLogConfig {
+	cfg := AccessLogConfig{RetentionDays: 7}
+	var s models.Setting
+	database.DB.Where("key = ?", "access_log").Limit(1).Find(&s)
+	if len(s.Value) > 0 {
+		if err := json.Unmarshal(s.Value, &cfg); err != nil {
+			slog.Warn("Invalid access_log setting", "err", err)
+		}
+	}
+	return cfg
+}
+
+func SaveAccessLogConfig(cfg AccessLogConfig) error {
+	if cfg.RetentionDays < 0 {
+		return errors.New("retention_days must not be negative")
+	}
+	val, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	saveSetting("access_log", val)
+	accessLog.apply(cfg)
+	return nil
+}
+
+func (l *accessLogger) apply(cfg AccessLogConfig) {
+	l.enabled.Store(cfg.Enabled)
+	l.retention.Store(int64(cfg.RetentionDays))
+}
+
+// begin returns a row to fill in for a new connection, or nil when the
+// access log is disabled.
+func (l *accessLogger) begin(engine, user, protocol, network, source, destination string) *models.AccessLog {
+	if !l.enabled.Load() {
+		return nil
+	}
+	return &models.AccessLog{
+		User:        user,
+		Engine:      engine,
+		Protocol:    protocol,
+		Network:     network,
+		Source:      source,
+		Destination: destination,
+		StartedAt:   time.Now(),
+	}
+}
+
+func (l *accessLogger) push(entry *models.AccessLog) {
+	select {
+	case l.queue <- entry:
+	default:
+		l.dropped.Add(1)
+	}
+}
+
+// Flush writes every queued row and returns once they are stored.
+func (l *accessLogger) Flush() {
+	done := make(chan struct{})
+	l.flushReq <- done
+	<-done
+}
+
+func (l *accessLogger) run() {
+	l.apply(LoadAccessLogConfig())
+
+	ticker := time.NewTicker(accessLogFlushInterval)
+	defer ticker.Stop()
+	cleanup := time.NewTicker(time.Hour)
+	defer cleanup.Stop()
+
+	l.cleanup()
+	batch := make([]*models.AccessLog, 0, accessLogBatch)
+	write := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := database.DB.CreateInBatches(batch, accessLogBatch).Error; err != nil {
+			slog.Error("Failed to write access log", "rows", len(batch), "err", err)
+		}
+		batch = batch[:0]
+		if n := l.dropped.Swap(0); n > 0 {
+			slog.Warn("Access log queue full, entries dropped", "count", n)
+		}
+	}
+
+	for {
+		select {
+		case entry := <-l.queue:
+			batch = append(batch, entry)
+			if len(batch) >= accessLogBatch {
+				write()
+			}
+		case <-ticker.C:
+			write()
+		case done := <-l.flushReq:
+			for len(l.queue) > 0 {
+				batch = append(batch, <-l.queue)
+			}
+			write()
+			close(done)
+		case <-cleanup.C:
+			l.cleanup()
+		}
+	}
+}
+
+// cleanup deletes rows older than the retention period.
+func (l *accessLogger) cleanup() {
+	days := l.retention.Load()
+	if days <= 0 {
+		return
+	}
+	cutoff := time.Now().AddDate(0, 0, -int(days))
+	res := database.DB.Where("started_at < ?", cutoff).Delete(&models.AccessLog{})
+	if res.Error != nil {
+		slog.Error("Failed to clean up access log", "err", res.Error)
+	} else if res.RowsAffected > 0 {
+		slog.Info("Cleaned up access log", "rows", res.RowsAffected)
+	}
+}
+
+// isNormalClose reports whether err is just the end of a connection rather
+// than a failure worth recording as its outcome.
+func isNormalClose(err error) bool {
+	return err == nil ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrClosedPipe) ||
+		errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, context.Canceled)
+}
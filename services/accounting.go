@@ -0,0 +1,247 @@
+package services
+
+import (
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"freegfw/database"
+	"freegfw/models"
+
+	"gorm.io/gorm"
+)
+
+// accountingFlushInterval is how often pending per-user traffic is written to SQLite.
+const accountingFlushInterval = 10 * time.Second
+
+// trafficCounter accumulates bytes for one local user between flushes.
+// Connection wrappers hold a pointer to it, so the hot path is a pair of
+// atomic adds and never touches a lock or the database.
+type trafficCounter struct {
+	up   atomic.Int64
+	down atomic.Int64
+}
+
+// trafficAccounting is the single accounting pipeline shared by the sing-box
+// and Xray engines. Wrappers in StatisticsTracker and XrayDispatcher resolve
+// the inbound user to a counter once per connection and add bytes to it; a
+// background loop flushes the counters in one transaction.
+type trafficAccounting struct {
+	mu       sync.Mutex
+	counters map[uint]*trafficCounter
+	// index maps both username and UUID to users.id. It is rebuilt lazily
+	// after InvalidateUserIndex is called.
+	index       map[string]uint
+	defaultUser uint // set when exactly one local user exists
+	// quotaNotified is the highest quota threshold already reported per user.
+	quotaNotified map[uint]int
+
+	totalUp   atomic.Int64
+	totalDown atomic.Int64
+}
+
+var accounting = &trafficAccounting{
+	counters: make(map[uint]*trafficCounter),
+}
+
+// InvalidateUserIndex must be called whenever local users are created,
+// renamed or deleted so that new connections resolve to the right row.
+func InvalidateUserIndex() {
+	accounting.mu.Lock()
+	accounting.index = nil
+	accounting.mu.Unlock()
+}
+
+// counterFor returns the counter of the local user identified by the inbound
+// user name (sing-box) or email (Xray). Unknown users, such as UUIDs synced
+// from linked nodes, return nil and are only counted in the totals.
+func (a *trafficAccounting) counterFor(user string) *trafficCounter {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.index == nil {
+		a.loadIndex()
+	}
+
+	id, ok := a.index[user]
+	if !ok {
+		// Fallback for connections without an identified user on single-user nodes
+		if user != "" || a.defaultUser == 0 {
+			return nil
+		}
+		id = a.defaultUser
+	}
+
+	c, ok := a.counters[id]
+	if !ok {
+		c = &trafficCounter{}
+		a.counters[id] = c
+	}
+	return c
+}
+
+// loadIndex must be called with a.mu held.
+func (a *trafficAccounting) loadIndex() {
+	var users []models.User
+	if err := database.DB.Select("id", "username", "uuid").Find(&users).Error; err != nil {
+		slog.Error("Failed to load users for accounting", "err", err)
+		a.index = map[string]uint{}
+		return
+	}
+
+	a.index = make(map[string]uint, len(users)*2)
+	a.defaultUser = 0
+	for _, u := range users {
+		if u.Username != "" {
+			a.index[u.Username] = u.ID
+		}
+		if u.UUID != "" {
+			a.index[u.UUID] = u.ID
+		}
+	}
+	if len(users) == 1 {
+		a.defaultUser = users[0].ID
+	}
+}
+
+// add records transferred bytes. c may be nil for unidentified traffic.
+func (a *trafficAccounting) add(c *trafficCounter, up, down int64) {
+	if up > 0 {
+		a.totalUp.Add(up)
+		if c != nil {
+			c.up.Add(up)
+		}
+	}
+	if down > 0 {
+		a.totalDown.Add(down)
+		if c != nil {
+			c.down.Add(down)
+		}
+	}
+}
+
+// totals returns the bytes seen by both engines since the process started.
+func (a *trafficAccounting) totals() (up, down int64) {
+	return a.totalUp.Load(), a.totalDown.Load()
+}
+
+type pendingTraffic struct {
+	Up, Down int64
+}
+
+// drain takes the pending bytes out of every counter.
+func (a *trafficAccounting) drain() map[uint]pendingTraffic {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var known map[uint]bool
+	if a.index != nil {
+		known = make(map[uint]bool, len(a.index))
+		for _, id := range a.index {
+			known[id] = true
+		}
+	}
+
+	pending := make(map[uint]pendingTraffic)
+	for id, c := range a.counters {
+		t := pendingTraffic{Up: c.up.Swap(0), Down: c.down.Swap(0)}
+		if t.Up > 0 || t.Down > 0 {
+			pending[id] = t
+		} else if known != nil && !known[id] {
+			// Drop idle counters of users that no longer exist
+			delete(a.counters, id)
+		}
+	}
+	return pending
+}
+
+// restore puts bytes back after a failed flush so the next one retries them.
+func (a *trafficAccounting) restore(pending map[uint]pendingTraffic) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for id, t := range pending {
+		c, ok := a.counters[id]
+		if !ok {
+			c = &trafficCounter{}
+			a.counters[id] = c
+		}
+		c.up.Add(t.Up)
+		c.down.Add(t.Down)
+	}
+}
+
+// Flush writes all pending per-user traffic to SQLite in a single
+// transaction. Increments are applied in SQL so concurrent writers can
+// never overwrite each other's totals.
+func (a *trafficAccounting) Flush() error {
+	pending := a.drain()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		for id, t := range pending {
+			if err := tx.Model(&models.User{}).Where("id = ?", id).Updates(map[string]interface{}{
+				"upload":   gorm.Expr("upload + ?", t.Up),
+				"download": gorm.Expr("download + ?", t.Down),
+			}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("Failed to flush user traffic", "err", err, "users", len(pending))
+		metrics.incFlushErrors()
+		a.restore(pending)
+		return err
+	}
+
+	ids := make([]uint, 0, len(pending))
+	for id := range pending {
+		ids = append(ids, id)
+	}
+	a.checkQuotas(ids)
+	return nil
+}
+
+// checkQuotas notifies when a user's usage crosses one of the configured
+// percentages of their quota. The highest threshold already reported is kept
+// in memory so every threshold fires once; it resets if usage drops again.
+func (a *trafficAccounting) checkQuotas(ids []uint) {
+	var users []models.User
+	if err := database.DB.Where("id IN ? AND quota > 0", ids).Find(&users).Error; err != nil || len(users) == 0 {
+		return
+	}
+	thresholds := LoadNotificationConfig().QuotaThresholds
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.quotaNotified == nil {
+		a.quotaNotified = make(map[uint]int)
+	}
+
+	for _, u := range users {
+		percent := int((u.Upload + u.Download) * 100 / u.Quota)
+		crossed := 0
+		for _, t := range thresholds {
+			if percent >= t && t > crossed {
+				crossed = t
+			}
+		}
+		if crossed > a.quotaNotified[u.ID] {
+			Notify(EventQuotaThreshold, "User %s has used %d%% of their quota (%d of %d bytes)", u.Username, percent, u.Upload+u.Download, u.Quota)
+		}
+		a.quotaNotified[u.ID] = crossed
+	}
+}
+
+func (a *trafficAccounting) run() {
+	ticker := time.NewTicker(accountingFlushInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		a.Flush()
+	}
+}
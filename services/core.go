@@ -4,7 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"log"
+	"log/slog"
 	"reflect"
 	"sync"
 	"time"
@@ -123,30 +123,41 @@ func (c *CoreService) Kill() error {
 }
 
 func (c *CoreService) Start() error {
-	log.Println("start engine:", c.CurrentEngine)
+	if err := c.start(); err != nil {
+		Notify(EventEngineStartFailed, "Failed to start %s: %v", c.CurrentEngine, err)
+		return err
+	}
+	return nil
+}
+
+func (c *CoreService) start() error {
+	slog.Info("Starting engine", "engine", c.CurrentEngine)
 	if len(c.ConfigContent) == 0 {
 		return nil
 	}
+	if c.instance != nil || c.xrayInstance != nil {
+		metrics.incEngineRestarts()
+	}
 	c.Kill()
 
 	if c.CurrentEngine == "xray" {
 		// Parse JSON config to Xray Core Config
-		log.Println("[Core] Xray Config JSON:", string(c.ConfigContent))
+		slog.Debug("Xray config", "json", string(c.ConfigContent))
 		coreConfig, err := serial.LoadJSONConfig(bytes.NewReader(c.ConfigContent))
 		if err != nil {
-			log.Println("Failed to parse xray config (json):", err)
+			slog.Error("Failed to parse xray config", "err", err)
 			return err
 		}
 
 		instance, err := xray_core.New(coreConfig)
 		if err != nil {
-			log.Println("Failed to create xray instance:", err)
+			slog.Error("Failed to create xray instance", "err", err)
 			return err
 		}
 
 		// Inject Custom Dispatcher for Rate Limiting and Stats
 		if dispFeature := instance.GetFeature(routing.DispatcherType()); dispFeature != nil {
-			log.Println("[Core] Found existing dispatcher feature")
+			slog.Debug("Found existing xray dispatcher feature")
 			if disp, ok := dispFeature.(routing.Dispatcher); ok {
 				if c.tracker == nil {
 					c.tracker = NewStatisticsTracker(nil, nil, c.UserLimits)
@@ -170,7 +181,7 @@ func (c *CoreService) Start() error {
 						if feat.Type() == routing.DispatcherType() {
 							fField.Index(i).Set(reflect.ValueOf(newDisp))
 							foundReplaced = true
-							log.Println("[Core] Replaced Xray dispatcher with custom dispatcher")
+							slog.Debug("Replaced xray dispatcher with custom dispatcher")
 						}
 
 						// Capture Stats Manager
@@ -192,7 +203,7 @@ func (c *CoreService) Start() error {
 						if t.Kind() == reflect.Ptr && t.Elem().Name() == "Manager" {
 							pkgPath := t.Elem().PkgPath()
 							if pkgPath == "github.com/xtls/xray-core/app/proxyman/inbound" || (len(pkgPath) > 7 && pkgPath[len(pkgPath)-7:] == "inbound") {
-								log.Printf("[Core] Inspecting InboundManager: %v", t)
+								slog.Debug("Inspecting xray inbound manager", "type", t.String())
 
 								vMgr := reflect.ValueOf(feat).Elem()
 								if vMgr.Kind() == reflect.Ptr {
@@ -229,7 +240,7 @@ func (c *CoreService) Start() error {
 
 									// Skip mux.Server to prevent hard-cast panic in Xray (s.dispatcher.(*dispatcher.DefaultDispatcher))
 									if vH.Type().Name() == "Server" && vH.Type().PkgPath() == "github.com/xtls/xray-core/common/mux" {
-										log.Println("[Core] Skipping recursive update for mux.Server to avoid DispatchLink panic")
+										slog.Debug("Skipping recursive update for mux.Server to avoid DispatchLink panic")
 										return
 									}
 
@@ -241,14 +252,14 @@ func (c *CoreService) Start() error {
 										// log.Printf("[Core] Field: %s Type: %v", fName, fType)
 
 										if fType == dispatcherType {
-											log.Printf("[Core] Updating dispatcher in Field: %s (Depth %d)", fName, depth)
+											slog.Debug("Updating xray dispatcher field", "field", fName, "depth", depth)
 											f = reflect.NewAt(f.Type(), unsafe.Pointer(f.UnsafeAddr())).Elem()
 											f.Set(reflect.ValueOf(newDisp))
 										}
 
 										// Recurse into workers slice
 										if fName == "workers" && f.Kind() == reflect.Slice {
-											log.Println("[Core] Recursing into workers slice")
+											slog.Debug("Recursing into xray workers slice")
 											f = reflect.NewAt(f.Type(), unsafe.Pointer(f.UnsafeAddr())).Elem()
 											for j := 0; j < f.Len(); j++ {
 												elem := f.Index(j)
@@ -274,7 +285,7 @@ func (c *CoreService) Start() error {
 									fName := vMgr.Type().Field(k).Name
 
 									if fName == "untaggedHandlers" && f.Kind() == reflect.Slice {
-										log.Println("[Core] Inspecting untaggedHandlers")
+										slog.Debug("Inspecting xray untaggedHandlers")
 										f = reflect.NewAt(f.Type(), unsafe.Pointer(f.UnsafeAddr())).Elem()
 										for j := 0; j < f.Len(); j++ {
 											handler := f.Index(j).Interface()
@@ -283,7 +294,7 @@ func (c *CoreService) Start() error {
 									}
 
 									if fName == "taggedHandlers" && f.Kind() == reflect.Map {
-										log.Println("[Core] Inspecting taggedHandlers")
+										slog.Debug("Inspecting xray taggedHandlers")
 										f = reflect.NewAt(f.Type(), unsafe.Pointer(f.UnsafeAddr())).Elem()
 										iter := f.MapRange()
 										for iter.Next() {
@@ -299,13 +310,13 @@ func (c *CoreService) Start() error {
 
 				if !foundReplaced {
 					instance.AddFeature(newDisp)
-					log.Println("[Core] Added custom dispatcher (no existing one found)")
+					slog.Debug("Added custom xray dispatcher (no existing one found)")
 				}
 			}
 		}
 
 		if err := instance.Start(); err != nil {
-			log.Println("Failed to start xray:", err)
+			slog.Error("Failed to start xray", "err", err)
 			return err
 		}
 
@@ -326,7 +337,7 @@ func (c *CoreService) Start() error {
 
 	var options option.Options
 	if err := options.UnmarshalJSONContext(ctx, c.ConfigContent); err != nil {
-		log.Println("Failed to parse singbox config:", err)
+		slog.Error("Failed to parse singbox config", "err", err)
 		return err
 	}
 
@@ -336,7 +347,7 @@ func (c *CoreService) Start() error {
 	})
 	if err != nil {
 		cancel()
-		log.Println("Failed to create singbox instance:", err)
+		slog.Error("Failed to create singbox instance", "err", err)
 		return err
 	}
 	c.instance = instance
@@ -348,7 +359,7 @@ func (c *CoreService) Start() error {
 
 	if err := instance.Start(); err != nil {
 		c.Kill()
-		log.Println("Failed to start singbox:", err)
+		slog.Error("Failed to start singbox", "err", err)
 		return err
 	}
 
@@ -0,0 +1,205 @@
+package services
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// destinationMinutes is the length of the rolling per-minute window.
+	destinationMinutes = 60
+	// destinationHours is how many hourly rollups are retained.
+	destinationHours = 24
+)
+
+type destinationKey struct {
+	Host string
+	User string
+}
+
+// destinationEntry holds cumulative counters for one host/user pair.
+// Connection wrappers add to it directly; the sampler turns the cumulative
+// values into per-minute deltas.
+type destinationEntry struct {
+	up     atomic.Int64
+	down   atomic.Int64
+	conns  atomic.Int64
+	active atomic.Int64
+
+	// Guarded by destinationStats.mu
+	sampledUp, sampledDown, sampledConns int64
+	lastActive                           time.Time
+}
+
+// DestinationTotals is one row of the destination statistics.
+type DestinationTotals struct {
+	Host        string `json:"host"`
+	User        string `json:"user,omitempty"`
+	Upload      int64  `json:"upload"`
+	Download    int64  `json:"download"`
+	Total       int64  `json:"total"`
+	Connections int64  `json:"connections"`
+}
+
+type destinationBucket struct {
+	start time.Time
+	stats map[destinationKey]*DestinationTotals
+}
+
+func newDestinationBucket(start time.Time) *destinationBucket {
+	return &destinationBucket{start: start, stats: make(map[destinationKey]*DestinationTotals)}
+}
+
+func (b *destinationBucket) add(key destinationKey, up, down, conns int64) {
+	t, ok := b.stats[key]
+	if !ok {
+		t = &DestinationTotals{Host: key.Host, User: key.User}
+		b.stats[key] = t
+	}
+	t.Upload += up
+	t.Download += down
+	t.Total += up + down
+	t.Connections += conns
+}
+
+// destinationStats aggregates traffic per destination host (or SNI) and
+// per user for both engines, keeping a rolling minute window and an
+// hourly rollup.
+type destinationStats struct {
+	mu      sync.Mutex
+	entries map[destinationKey]*destinationEntry
+	minutes []*destinationBucket // newest last
+	hours   []*destinationBucket // completed hours, newest last
+	hour    *destinationBucket   // hour in progress
+}
+
+var destinations = &destinationStats{
+	entries: make(map[destinationKey]*destinationEntry),
+}
+
+// open registers a new connection and returns the entry it should count into.
+func (s *destinationStats) open(host, user string) *destinationEntry {
+	if host == "" {
+		return nil
+	}
+	key := destinationKey{Host: host, User: user}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok {
+		e = &destinationEntry{}
+		s.entries[key] = e
+	}
+	// Counted under the lock so sample cannot evict the entry before it
+	// is marked active
+	e.conns.Add(1)
+	e.active.Add(1)
+	return e
+}
+
+func (e *destinationEntry) close() {
+	e.active.Add(-1)
+}
+
+// sample moves the bytes seen since the previous call into a new minute bucket.
+func (s *destinationStats) sample(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hourStart := now.Truncate(time.Hour)
+	if s.hour == nil {
+		s.hour = newDestinationBucket(hourStart)
+	} else if !s.hour.start.Equal(hourStart) {
+		s.hours = append(s.hours, s.hour)
+		if len(s.hours) > destinationHours {
+			s.hours = s.hours[len(s.hours)-destinationHours:]
+		}
+		s.hour = newDestinationBucket(hourStart)
+	}
+
+	minute := newDestinationBucket(now.Truncate(time.Minute))
+	for key, e := range s.entries {
+		up, down, conns := e.up.Load(), e.down.Load(), e.conns.Load()
+		dUp, dDown, dConns := up-e.sampledUp, down-e.sampledDown, conns-e.sampledConns
+		e.sampledUp, e.sampledDown, e.sampledConns = up, down, conns
+
+		if dUp > 0 || dDown > 0 || dConns > 0 {
+			minute.add(key, dUp, dDown, dConns)
+			s.hour.add(key, dUp, dDown, dConns)
+			e.lastActive = now
+		}
+
+		// Forget destinations that have been idle for the whole window
+		if e.active.Load() <= 0 && now.Sub(e.lastActive) > destinationMinutes*time.Minute {
+			delete(s.entries, key)
+		}
+	}
+
+	s.minutes = append(s.minutes, minute)
+	if len(s.minutes) > destinationMinutes {
+		s.minutes = s.minutes[len(s.minutes)-destinationMinutes:]
+	}
+}
+
+func (s *destinationStats) run() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		s.sample(now)
+	}
+}
+
+// TopDestinations returns the destinations with the most traffic within the
+// given window. Windows up to an hour use the per-minute buckets, longer
+// ones the hourly rollup (at most 24 hours). When user is empty the rows
+// are merged across users.
+func TopDestinations(window time.Duration, user string, limit int) []DestinationTotals {
+	s := destinations
+	s.mu.Lock()
+	var buckets []*destinationBucket
+	if window <= destinationMinutes*time.Minute {
+		n := int((window + time.Minute - 1) / time.Minute)
+		if n > len(s.minutes) {
+			n = len(s.minutes)
+		}
+		buckets = s.minutes[len(s.minutes)-n:]
+	} else {
+		n := int((window+time.Hour-1)/time.Hour) - 1
+		if n > len(s.hours) {
+			n = len(s.hours)
+		}
+		buckets = append(buckets, s.hours[len(s.hours)-n:]...)
+		if s.hour != nil {
+			buckets = append(buckets, s.hour)
+		}
+	}
+
+	merged := newDestinationBucket(time.Time{})
+	for _, b := range buckets {
+		for key, t := range b.stats {
+			if user != "" && key.User != user {
+				continue
+			}
+			if user == "" {
+				key.User = ""
+			}
+			merged.add(key, t.Upload, t.Download, t.Connections)
+		}
+	}
+	s.mu.Unlock()
+
+	res := make([]DestinationTotals, 0, len(merged.stats))
+	for _, t := range merged.stats {
+		res = append(res, *t)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Total > res[j].Total
+	})
+	if limit > 0 && len(res) > limit {
+		res = res[:limit]
+	}
+	return res
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTopDestinationsWindows(t *testing.T) {
+	saved := destinations
+	destinations = &destinationStats{entries: make(map[destinationKey]*destinationEntry)}
+	t.Cleanup(func() { destinations = saved })
+	s := destinations
+
+	top := func(window time.Duration, user string) map[string]int64 {
+		totals := map[string]int64{}
+		for _, d := range TopDestinations(window, user, 0) {
+			totals[d.Host] = d.Total
+		}
+		return totals
+	}
+	check := func(when string, window time.Duration, user string, want map[string]int64) {
+		t.Helper()
+		got := top(window, user)
+		if len(got) != len(want) {
+			t.Errorf("%s, %v window: got %v, want %v", when, window, got, want)
+			return
+		}
+		for host, total := range want {
+			if got[host] != total {
+				t.Errorf("%s, %v window: got %v, want %v", when, window, got, want)
+				return
+			}
+		}
+	}
+
+	start := time.Date(2026, 1, 1, 10, 58, 30, 0, time.UTC)
+	a := s.open("a.example", "alice")
+	a.up.Add(100)
+	a.close()
+	s.sample(start)
+
+	b := s.open("b.example", "bob")
+	b.down.Add(50)
+	s.sample(start.Add(time.Minute)) // 10:59:30
+
+	a = s.open("a.example", "alice")
+	a.up.Add(10)
+	a.close()
+	s.open("a.example", "bob").down.Add(5)
+	s.sample(start.Add(2 * time.Minute)) // 11:00:30, a new hour
+
+	check("11:00", time.Minute, "", map[string]int64{"a.example": 15})
+	check("11:00", 2*time.Minute, "", map[string]int64{"a.example": 15, "b.example": 50})
+	check("11:00", time.Hour, "", map[string]int64{"a.example": 115, "b.example": 50})
+	check("11:00", time.Hour, "alice", map[string]int64{"a.example": 110})
+	// The finished hour and the one in progress
+	check("11:00", 2*time.Hour, "", map[string]int64{"a.example": 115, "b.example": 50})
+	check("11:00", 2*time.Hour, "bob", map[string]int64{"a.example": 5, "b.example": 50})
+
+	// Idle for a whole minute window
+	now := start.Add(2 * time.Minute)
+	for i := 0; i < destinationMinutes; i++ {
+		now = now.Add(time.Minute)
+		s.sample(now)
+	}
+	check("12:00", time.Hour, "", map[string]int64{})
+	// The hours are whole: the one in progress and the previous ones
+	check("12:00", 2*time.Hour, "", map[string]int64{"a.example": 15})
+	check("12:00", 3*time.Hour, "", map[string]int64{"a.example": 115, "b.example": 50})
+	now = now.Add(time.Minute)
+	s.sample(now)
+	if _, ok := s.entries[destinationKey{"a.example", "alice"}]; ok {
+		t.Error("idle destination kept")
+	}
+	if _, ok := s.entries[destinationKey{"b.example", "bob"}]; !ok {
+		t.Error("destination with an open connection forgotten")
+	}
+
+	// Once the 10:00 hour is more than a day old it is dropped
+	for now.Before(time.Date(2026, 1, 2, 10, 0, 0, 0, time.UTC)) {
+		now = now.Add(time.Hour)
+		s.sample(now)
+	}
+	check("10:00 next day", 24*time.Hour, "", map[string]int64{"a.example": 15})
+	b.down.Add(7)
+	b.close()
+	s.sample(now.Add(time.Minute))
+	check("10:00 next day", 24*time.Hour, "", map[string]int64{"a.example": 15, "b.example": 7})
+	check("10:00 next day", time.Minute, "", map[string]int64{"b.example": 7})
+}
@@ -4,7 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"reflect"
 	"unsafe"
 
@@ -21,7 +21,7 @@ type getInbound interface {
 }
 
 func (c *CoreService) HotReloadUsers() error {
-	log.Println("[HotReload] Attempting to hot-reload users into memory...")
+	slog.Info("Hot-reloading users into memory")
 
 	// 1. Fetch current users from database dynamically just like BuildUsers
 	var t models.Setting
@@ -304,7 +304,7 @@ func (c *CoreService) HotReloadUsers() error {
 			}
 		}
 		
-		log.Println("[HotReload] Sing-box memory users updated successfully using Reflection!")
+		slog.Info("Sing-box users hot-reloaded")
 		return nil
 	}
 
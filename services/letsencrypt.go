@@ -8,7 +8,8 @@ import (
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
-	"log"
+	"errors"
+	"log/slog"
 	"net"
 	"os"
 	"time"
@@ -154,27 +155,37 @@ func StartCertificateRenewalLoop() {
 	}
 }
 
-func CheckAndRenewCertificate() {
-	certFile := "data/certificate.crt"
-	certBytes, err := os.ReadFile(certFile)
+// CertificateNotAfter returns the expiry time of the certificate in data/.
+func CertificateNotAfter() (time.Time, error) {
+	certBytes, err := os.ReadFile("data/certificate.crt")
 	if err != nil {
-		return
+		return time.Time{}, err
 	}
 
 	block, _ := pem.Decode(certBytes)
 	if block == nil {
-		return
+		return time.Time{}, errors.New("no PEM block found in certificate")
 	}
 
 	cert, err := x509.ParseCertificate(block.Bytes)
 	if err != nil {
-		log.Println("Failed to parse certificate:", err)
+		return time.Time{}, err
+	}
+	return cert.NotAfter, nil
+}
+
+func CheckAndRenewCertificate() {
+	notAfter, err := CertificateNotAfter()
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Error("Failed to parse certificate", "err", err)
+		}
 		return
 	}
 
 	// Check if certificate expires in less than 24 hours
-	if time.Until(cert.NotAfter) < 24*time.Hour {
-		log.Println("Certificate expires in less than 24 hours. Attempting renewal...")
+	if time.Until(notAfter) < 24*time.Hour {
+		slog.Warn("Certificate expires in less than 24 hours, attempting renewal")
 
 		var emailSetting models.Setting
 		database.DB.Where("key = ?", "letsencrypt_email").Limit(1).Find(&emailSetting)
@@ -194,9 +205,9 @@ func CheckAndRenewCertificate() {
 
 		if email != "" && domain != "" {
 			if err := ApplyCertificate(domain, email); err != nil {
-				log.Println("Failed to renew certificate:", err)
+				Notify(EventCertRenewalFailed, "Failed to renew certificate for %s (expires %s): %v", domain, notAfter.Format(time.RFC3339), err)
 			} else {
-				log.Println("Certificate renewed successfully. Requesting server restart.")
+				slog.Info("Certificate renewed, requesting server restart")
 				go func() {
 					time.Sleep(5 * time.Second) // Delay to ensure other operations complete
 					RestartChan <- struct{}{}
@@ -213,7 +224,7 @@ func CheckAndRenewCertificate() {
 				database.DB.Save(&s)
 			}
 		} else {
-			log.Println("Cannot renew certificate: Email or Domain not found in settings.")
+			slog.Error("Cannot renew certificate: email or domain not found in settings")
 		}
 	}
 }
@@ -0,0 +1,217 @@
+package services
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"freegfw/database"
+	"freegfw/models"
+)
+
+// Linked nodes sign every sync request and response with their ed25519
+// identity key. Keys are exchanged and pinned when the link is made; the
+// link code alone no longer authenticates a peer.
+const (
+	linkKeyHeader       = "X-FreeGFW-Key"
+	linkTimestampHeader = "X-FreeGFW-Timestamp"
+	linkNonceHeader     = "X-FreeGFW-Nonce"
+	linkSignatureHeader = "X-FreeGFW-Signature"
+
+	// linkMaxSkew is how far the timestamp of a signed message may be from
+	// the local clock.
+	linkMaxSkew = 5 * time.Minute
+)
+
+var (
+	ErrLinkUnsigned    = errors.New("link message is not signed")
+	ErrLinkSignature   = errors.New("invalid link signature")
+	ErrLinkStale       = errors.New("link message timestamp out of range")
+	ErrLinkReplay      = errors.New("link request replayed")
+	ErrLinkKeyMismatch = errors.New("link peer key does not match the pinned key")
+	ErrLinkKeyMissing  = errors.New("link peer key is not pinned; re-link or confirm the peer's key")
+)
+
+var identity struct {
+	sync.Mutex
+	key ed25519.PrivateKey
+}
+
+// identityKey returns the node's ed25519 identity key, generating and
+// storing it in the "identity_key" setting on first use.
+func identityKey() ed25519.PrivateKey {
+	identity.Lock()
+	defer identity.Unlock()
+	if identity.key != nil {
+		return identity.key
+	}
+
+	var s models.Setting
+	database.DB.Where("key = ?", "identity_key").Limit(1).Find(&s)
+	var encoded string
+	if len(s.Value) > 0 {
+		json.Unmarshal(s.Value, &encoded)
+	}
+	if seed, err := base64.StdEncoding.DecodeString(encoded); err == nil && len(seed) == ed25519.SeedSize {
+		identity.key = ed25519.NewKeyFromSeed(seed)
+		return identity.key
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		// crypto/rand does not fail on supported platforms
+		panic(err)
+	}
+	val, _ := json.Marshal(base64.StdEncoding.EncodeToString(key.Seed()))
+	saveSetting("identity_key", val)
+	slog.Info("Generated node identity key")
+	identity.key = key
+	return key
+}
+
+// IdentityPublicKey returns the node's public key as peers pin it.
+func IdentityPublicKey() string {
+	return base64.RawURLEncoding.EncodeToString(identityKey().Public().(ed25519.PublicKey))
+}
+
+// ValidLinkKey reports whether key is a public key as IdentityPublicKey
+// returns it.
+func ValidLinkKey(key string) bool {
+	pub, err := base64.RawURLEncoding.DecodeString(key)
+	return err == nil && len(pub) == ed25519.PublicKeySize
+}
+
+// SplitLinkKey separates the identity key that CreateLink appends to link
+// URLs as the fragment, so the peer can be pinned before first contact.
+func SplitLinkKey(link string) (string, string) {
+	u, key, _ := strings.Cut(link, "#")
+	return u, key
+}
+
+// linkMessage is what gets signed: the direction, the request line (empty
+// for responses), timestamp, nonce and a hash of the body.
+func linkMessage(kind, request, ts, nonce string, body []byte) []byte {
+	sum := sha256.Sum256(body)
+	return []byte(strings.Join([]string{"freegfw-link-v1", kind, request, ts, nonce, hex.EncodeToString(sum[:])}, "\n"))
+}
+
+func signLinkMessage(h http.Header, msg []byte, ts string) {
+	h.Set(linkKeyHeader, IdentityPublicKey())
+	h.Set(linkTimestampHeader, ts)
+	h.Set(linkSignatureHeader, base64.RawURLEncoding.EncodeToString(ed25519.Sign(identityKey(), msg)))
+}
+
+// verifyLinkMessage checks the signature and timestamp in h and returns the
+// signer's key. pinned, if set, is the only key accepted.
+func verifyLinkMessage(h http.Header, kind, request, nonce string, body []byte, pinned string) (string, error) {
+	key, ts, sig := h.Get(linkKeyHeader), h.Get(linkTimestampHeader), h.Get(linkSignatureHeader)
+	if key == "" || ts == "" || sig == "" || nonce == "" {
+		return "", ErrLinkUnsigned
+	}
+	if pinned != "" && key != pinned {
+		return "", ErrLinkKeyMismatch
+	}
+	pub, err := base64.RawURLEncoding.DecodeString(key)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return "", ErrLinkSignature
+	}
+	rawSig, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !ed25519.Verify(pub, linkMessage(kind, request, ts, nonce, body), rawSig) {
+		return "", ErrLinkSignature
+	}
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return "", ErrLinkStale
+	}
+	if d := time.Since(time.Unix(sec, 0)); d > linkMaxSkew || d < -linkMaxSkew {
+		return "", ErrLinkStale
+	}
+	return key, nil
+}
+
+// NewLinkRequest builds a signed POST of body to a peer's link URL. The
+// returned nonce is needed to verify the response.
+func NewLinkRequest(url string, body []byte) (*http.Request, string, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	b := make([]byte, 16)
+	rand.Read(b)
+	nonce := hex.EncodeToString(b)
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(linkNonceHeader, nonce)
+	signLinkMessage(req.Header, linkMessage("request", req.Method+" "+req.URL.Path, ts, nonce, body), ts)
+	return req, nonce, nil
+}
+
+// VerifyLinkResponse checks that resp was signed by the peer for the request
+// with the given nonce and returns the peer's key. pinned, if set, is the
+// only key accepted.
+func VerifyLinkResponse(resp *http.Response, body []byte, nonce, pinned string) (string, error) {
+	return verifyLinkMessage(resp.Header, "response", "", nonce, body, pinned)
+}
+
+// VerifyLinkRequest checks the signature, timestamp and nonce of a request
+// from a peer and returns the peer's key. pinned, if set, is the only key
+// accepted.
+func VerifyLinkRequest(r *http.Request, body []byte, pinned string) (string, error) {
+	nonce := r.Header.Get(linkNonceHeader)
+	key, err := verifyLinkMessage(r.Header, "request", r.Method+" "+r.URL.Path, nonce, body, pinned)
+	if err != nil {
+		return "", err
+	}
+	if !linkNonces.add(key+":"+nonce, time.Now()) {
+		return "", ErrLinkReplay
+	}
+	return key, nil
+}
+
+// SignLinkResponse adds the signature of the response body to h, binding
+// it to the nonce of the request it answers.
+func SignLinkResponse(h http.Header, r *http.Request, body []byte) {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	signLinkMessage(h, linkMessage("response", "", ts, r.Header.Get(linkNonceHeader), body), ts)
+}
+
+// nonceCache remembers request nonces for as long as their timestamps are
+// accepted, which is enough to reject every replay.
+type nonceCache struct {
+	mu        sync.Mutex
+	seen      map[string]time.Time
+	lastSweep time.Time
+}
+
+var linkNonces = &nonceCache{seen: make(map[string]time.Time)}
+
+// add records the nonce and reports whether it was new.
+func (n *nonceCache) add(nonce string, now time.Time) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if now.Sub(n.lastSweep) > time.Minute {
+		for k, t := range n.seen {
+			if now.Sub(t) > 2*linkMaxSkew {
+				delete(n.seen, k)
+			}
+		}
+		n.lastSweep = now
+	}
+	if _, ok := n.seen[nonce]; ok {
+		return false
+	}
+	n.seen[nonce] = now
+	return true
+}
@@ -0,0 +1,126 @@
+package services
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testIdentity gives the node a fresh identity key for the test.
+func testIdentity(t *testing.T) {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity.key = key
+	t.Cleanup(func() { identity.key = nil })
+}
+
+func TestVerifyLinkRequest(t *testing.T) {
+	testIdentity(t)
+	body := []byte(`{"users":[]}`)
+	own := IdentityPublicKey()
+	other := base64.RawURLEncoding.EncodeToString(make([]byte, ed25519.PublicKeySize))
+
+	signed := func() *http.Request {
+		t.Helper()
+		req, _, err := NewLinkRequest("http://203.0.113.7/link/abc", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return req
+	}
+	// stale signs the request as if it was made at the given time
+	stale := func(at time.Time) *http.Request {
+		req := signed()
+		ts := strconv.FormatInt(at.Unix(), 10)
+		msg := linkMessage("request", req.Method+" "+req.URL.Path, ts, req.Header.Get(linkNonceHeader), body)
+		signLinkMessage(req.Header, msg, ts)
+		return req
+	}
+	unsigned := signed()
+	unsigned.Header.Del(linkSignatureHeader)
+	otherPath := signed()
+	otherPath.URL.Path = "/link/other"
+
+	for _, tc := range []struct {
+		name   string
+		req    *http.Request
+		body   string
+		pinned string
+		want   error
+	}{
+		{"valid", signed(), string(body), "", nil},
+		{"valid with pinned key", signed(), string(body), own, nil},
+		{"tampered body", signed(), `{"users":[{}]}`, "", ErrLinkSignature},
+		{"other path", otherPath, string(body), "", ErrLinkSignature},
+		{"unsigned", unsigned, string(body), "", ErrLinkUnsigned},
+		{"stale", stale(time.Now().Add(-linkMaxSkew - time.Minute)), string(body), "", ErrLinkStale},
+		{"from the future", stale(time.Now().Add(linkMaxSkew + time.Minute)), string(body), "", ErrLinkStale},
+		{"within the skew", stale(time.Now().Add(-linkMaxSkew + time.Minute)), string(body), "", nil},
+		{"other pinned key", signed(), string(body), other, ErrLinkKeyMismatch},
+	} {
+		key, err := VerifyLinkRequest(tc.req, []byte(tc.body), tc.pinned)
+		if err != tc.want {
+			t.Errorf("%s: err = %v, want %v", tc.name, err, tc.want)
+		}
+		if err == nil && key != own {
+			t.Errorf("%s: key = %q, want %q", tc.name, key, own)
+		}
+	}
+
+	req := signed()
+	if _, err := VerifyLinkRequest(req, body, own); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyLinkRequest(req, body, own); err != ErrLinkReplay {
+		t.Errorf("replayed request: err = %v, want %v", err, ErrLinkReplay)
+	}
+}
+
+func TestVerifyLinkResponse(t *testing.T) {
+	testIdentity(t)
+	req, nonce, err := NewLinkRequest("http://203.0.113.7/link/abc", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := []byte(`{"users":[]}`)
+	resp := &http.Response{Header: http.Header{}}
+	SignLinkResponse(resp.Header, req, body)
+
+	if key, err := VerifyLinkResponse(resp, body, nonce, IdentityPublicKey()); err != nil || key != IdentityPublicKey() {
+		t.Errorf("VerifyLinkResponse = %q, %v", key, err)
+	}
+	if _, err := VerifyLinkResponse(resp, []byte(`{}`), nonce, ""); err != ErrLinkSignature {
+		t.Errorf("tampered body: err = %v, want %v", err, ErrLinkSignature)
+	}
+	// A response recorded for another request
+	if _, err := VerifyLinkResponse(resp, body, "0123456789abcdef", ""); err != ErrLinkSignature {
+		t.Errorf("other nonce: err = %v, want %v", err, ErrLinkSignature)
+	}
+
+	pinned := IdentityPublicKey()
+	testIdentity(t)
+	SignLinkResponse(resp.Header, req, body)
+	if _, err := VerifyLinkResponse(resp, body, nonce, pinned); err != ErrLinkKeyMismatch {
+		t.Errorf("other key: err = %v, want %v", err, ErrLinkKeyMismatch)
+	}
+}
+
+func TestNonceCache(t *testing.T) {
+	n := &nonceCache{seen: make(map[string]time.Time)}
+	start := time.Unix(1700000000, 0)
+	if !n.add("a", start) || n.add("a", start.Add(linkMaxSkew)) {
+		t.Fatal("replay within the skew accepted")
+	}
+	// Once a timestamp is too old to be accepted the nonce may be forgotten
+	n.add("b", start.Add(2*linkMaxSkew+2*time.Minute))
+	if _, ok := n.seen["a"]; ok {
+		t.Error("expired nonce kept")
+	}
+}
@@ -0,0 +1,73 @@
+package services
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"freegfw/database"
+	"freegfw/models"
+)
+
+// linkNotifyDelay coalesces bursts of changes, such as adding many users,
+// into one notification per peer.
+const linkNotifyDelay = 2 * time.Second
+
+var linkNotify = &debouncer{delay: linkNotifyDelay, fn: pushLinkNotifications}
+
+// NotifyLinkPeers tells every linked peer that what it syncs from us
+// (users, server settings or title) changed, so it syncs right away
+// instead of at its next poll.
+func NotifyLinkPeers() {
+	linkNotify.trigger()
+}
+
+// debouncer runs fn once delay has passed without another trigger.
+type debouncer struct {
+	mu    sync.Mutex
+	timer *time.Timer
+	delay time.Duration
+	fn    func()
+}
+
+func (d *debouncer) trigger() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.timer == nil {
+		d.timer = time.AfterFunc(d.delay, d.fn)
+		return
+	}
+	d.timer.Reset(d.delay)
+}
+
+func pushLinkNotifications() {
+	var links []models.Link
+	database.DB.Find(&links)
+	for _, link := range links {
+		go notifyLinkPeer(link)
+	}
+}
+
+// notifyLinkPeer posts a signed notification to the peer's link URL. A
+// failure is only logged; the peer still picks up the change when it
+// polls.
+func notifyLinkPeer(link models.Link) {
+	myLink, _ := GetMyLink(link.LocalCode)
+	body, _ := json.Marshal(map[string]string{"link": myLink})
+	req, _, err := NewLinkRequest(link.Link+"/notify", body)
+	if err != nil {
+		return
+	}
+	resp, err := syncHTTPClient.Do(req)
+	if err != nil {
+		slog.Debug("Failed to notify link peer", "link", link.ID, "err", err)
+		return
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseBodyBytes)) //nolint:errcheck
+	if resp.StatusCode != 200 {
+		slog.Debug("Link peer rejected notification", "link", link.ID, "status", resp.StatusCode)
+	}
+}
@@ -0,0 +1,204 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"freegfw/database"
+	"freegfw/models"
+)
+
+// logRingSize is how many recent records are kept for /stream/logs.
+const logRingSize = 1000
+
+// LogConfig is stored as JSON in the "logging" setting.
+type LogConfig struct {
+	// Level is the panel's own level: debug, info, warn or error.
+	Level string `json:"level"`
+	// SingboxLevel is passed to sing-box: trace, debug, info, warn, error, fatal or panic.
+	SingboxLevel string `json:"singbox_level"`
+	// XrayLevel is passed to Xray: debug, info, warning, error or none.
+	XrayLevel string `json:"xray_level"`
+}
+
+// LogEntry is one record of the in-memory ring.
+type LogEntry struct {
+	Time    time.Time              `json:"time"`
+	Level   string                 `json:"level"`
+	Message string                 `json:"message"`
+	Attrs   map[string]interface{} `json:"attrs,omitempty"`
+
+	// seq numbers the records in the order the ring received them
+	seq uint64
+}
+
+var logLevel = new(slog.LevelVar)
+
+type logRing struct {
+	mu      sync.Mutex
+	entries []LogEntry
+	next    int
+	full    bool
+	seq     uint64
+}
+
+var recentLogs = &logRing{entries: make([]LogEntry, logRingSize)}
+
+// add stores the record and returns its sequence number.
+func (r *logRing) add(e LogEntry) uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seq++
+	e.seq = r.seq
+	r.entries[r.next] = e
+	r.next = (r.next + 1) % len(r.entries)
+	if r.next == 0 {
+		r.full = true
+	}
+	return e.seq
+}
+
+// list returns the buffered records, oldest first.
+func (r *logRing) list() []LogEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.full {
+		return append([]LogEntry(nil), r.entries[:r.next]...)
+	}
+	res := make([]LogEntry, 0, len(r.entries))
+	res = append(res, r.entries[r.next:]...)
+	return append(res, r.entries[:r.next]...)
+}
+
+// RecentLogs returns the buffered log records, oldest first.
+func RecentLogs() []LogEntry {
+	return recentLogs.list()
+}
+
+// ringHandler writes records to the wrapped handler and also keeps them in
+// the ring and pushes them to LogHub subscribers.
+type ringHandler struct {
+	slog.Handler
+	attrs  []slog.Attr
+	prefix string
+}
+
+func (h *ringHandler) Handle(ctx context.Context, r slog.Record) error {
+	err := h.Handler.Handle(ctx, r)
+
+	entry := LogEntry{Time: r.Time, Level: r.Level.String(), Message: r.Message}
+	if len(h.attrs) > 0 || r.NumAttrs() > 0 {
+		entry.Attrs = make(map[string]interface{}, len(h.attrs)+r.NumAttrs())
+		for _, a := range h.attrs {
+			addLogAttr(entry.Attrs, "", a)
+		}
+		r.Attrs(func(a slog.Attr) bool {
+			addLogAttr(entry.Attrs, h.prefix, a)
+			return true
+		})
+	}
+	seq := recentLogs.add(entry)
+	if LogHub != nil {
+		LogHub.send(sseEvent(seq, "log", entry))
+	}
+	return err
+}
+
+func (h *ringHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	prefixed := make([]slog.Attr, 0, len(h.attrs)+len(attrs))
+	prefixed = append(prefixed, h.attrs...)
+	for _, a := range attrs {
+		a.Key = h.prefix + a.Key
+		prefixed = append(prefixed, a)
+	}
+	return &ringHandler{Handler: h.Handler.WithAttrs(attrs), attrs: prefixed, prefix: h.prefix}
+}
+
+func (h *ringHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &ringHandler{Handler: h.Handler.WithGroup(name), attrs: h.attrs, prefix: h.prefix + name + "."}
+}
+
+func addLogAttr(m map[string]interface{}, prefix string, a slog.Attr) {
+	v := a.Value.Resolve()
+	if v.Kind() == slog.KindGroup {
+		for _, ga := range v.Group() {
+			addLogAttr(m, prefix+a.Key+".", ga)
+		}
+		return
+	}
+	if err, ok := v.Any().(error); ok {
+		m[prefix+a.Key] = err.Error()
+		return
+	}
+	m[prefix+a.Key] = v.Any()
+}
+
+// InitLogging installs the leveled logger as the default for both slog and
+// the standard log package. It must run after the database is connected.
+func InitLogging() {
+	handler := slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: logLevel})
+	slog.SetDefault(slog.New(&ringHandler{Handler: handler}))
+	SetLogLevel(LoadLogConfig().Level)
+}
+
+// SetLogLevel changes the panel's log level at runtime.
+func SetLogLevel(level string) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		l = slog.LevelInfo
+	}
+	logLevel.Set(l)
+}
+
+func LoadLogConfig() LogConfig {
+	cfg := LogConfig{Level: "info", SingboxLevel: "info", XrayLevel: "info"}
+	var s models.Setting
+	database.DB.Where("key = ?", "logging").Limit(1).Find(&s)
+	if len(s.Value) > 0 {
+		if err := json.Unmarshal(s.Value, &cfg); err != nil {
+			slog.Warn("Invalid logging setting", "err", err)
+		}
+	}
+	cfg.Level = strings.ToLower(cfg.Level)
+	cfg.SingboxLevel = strings.ToLower(cfg.SingboxLevel)
+	cfg.XrayLevel = strings.ToLower(cfg.XrayLevel)
+	return cfg
+}
+
+func SaveLogConfig(cfg LogConfig) error {
+	val, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	saveSetting("logging", val)
+	SetLogLevel(cfg.Level)
+	return nil
+}
+
+// Validate checks the levels against what each logger accepts.
+func (cfg LogConfig) Validate() error {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(cfg.Level)); err != nil {
+		return fmt.Errorf("invalid level %q", cfg.Level)
+	}
+	switch cfg.SingboxLevel {
+	case "trace", "debug", "info", "warn", "error", "fatal", "panic":
+	default:
+		return fmt.Errorf("invalid singbox_level %q", cfg.SingboxLevel)
+	}
+	switch cfg.XrayLevel {
+	case "debug", "info", "warning", "error", "none":
+	default:
+		return fmt.Errorf("invalid xray_level %q", cfg.XrayLevel)
+	}
+	return nil
+}
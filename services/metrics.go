@@ -0,0 +1,173 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"freegfw/database"
+	"freegfw/models"
+)
+
+// metricsState holds runtime values that are only known to the monitor and
+// sync loops. Everything that already lives in SQLite (user counters, link
+// status) is read at scrape time instead.
+type metricsState struct {
+	mu              sync.RWMutex
+	speedUp         int64 // bytes per second over the last monitor tick
+	speedDown       int64
+	userConnections map[string]int
+	engineRestarts  uint64
+	flushErrors     uint64
+	linkLatency     map[uint]time.Duration
+}
+
+var metrics = &metricsState{
+	userConnections: make(map[string]int),
+	linkLatency:     make(map[uint]time.Duration),
+}
+
+func (m *metricsState) setSpeed(up, down int64) {
+	m.mu.Lock()
+	m.speedUp = up
+	m.speedDown = down
+	m.mu.Unlock()
+}
+
+func (m *metricsState) setUserConnections(conns map[string]int) {
+	m.mu.Lock()
+	m.userConnections = conns
+	m.mu.Unlock()
+}
+
+func (m *metricsState) incEngineRestarts() {
+	m.mu.Lock()
+	m.engineRestarts++
+	m.mu.Unlock()
+}
+
+func (m *metricsState) incFlushErrors() {
+	m.mu.Lock()
+	m.flushErrors++
+	m.mu.Unlock()
+}
+
+func (m *metricsState) setLinkLatency(id uint, d time.Duration) {
+	m.mu.Lock()
+	m.linkLatency[id] = d
+	m.mu.Unlock()
+}
+
+// WriteMetrics renders all exported metrics in the Prometheus text
+// exposition format (version 0.0.4).
+func WriteMetrics(w io.Writer) {
+	var users []models.User
+	database.DB.Find(&users)
+
+	writeHeader(w, "freegfw_user_upload_bytes_total", "Total bytes uploaded by the user.", "counter")
+	for _, u := range users {
+		writeSample(w, "freegfw_user_upload_bytes_total", labels("user", u.Username), float64(u.Upload))
+	}
+	writeHeader(w, "freegfw_user_download_bytes_total", "Total bytes downloaded by the user.", "counter")
+	for _, u := range users {
+		writeSample(w, "freegfw_user_download_bytes_total", labels("user", u.Username), float64(u.Download))
+	}
+
+	metrics.mu.RLock()
+	speedUp, speedDown := metrics.speedUp, metrics.speedDown
+	restarts, flushErrors := metrics.engineRestarts, metrics.flushErrors
+	userConns := make(map[string]int, len(metrics.userConnections))
+	for k, v := range metrics.userConnections {
+		userConns[k] = v
+	}
+	latency := make(map[uint]time.Duration, len(metrics.linkLatency))
+	for k, v := range metrics.linkLatency {
+		latency[k] = v
+	}
+	metrics.mu.RUnlock()
+
+	writeHeader(w, "freegfw_user_active_connections", "Currently open connections per user.", "gauge")
+	names := make([]string, 0, len(userConns))
+	for name := range userConns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		writeSample(w, "freegfw_user_active_connections", labels("user", name), float64(userConns[name]))
+	}
+
+	writeHeader(w, "freegfw_speed_bytes_per_second", "Current aggregate throughput.", "gauge")
+	writeSample(w, "freegfw_speed_bytes_per_second", labels("direction", "up"), float64(speedUp))
+	writeSample(w, "freegfw_speed_bytes_per_second", labels("direction", "down"), float64(speedDown))
+
+	core := NewCoreService()
+	running := 0.0
+	if core.IsRunning() {
+		running = 1
+	}
+	writeHeader(w, "freegfw_engine_up", "Whether the proxy engine is running.", "gauge")
+	writeSample(w, "freegfw_engine_up", labels("engine", core.CurrentEngine), running)
+	writeHeader(w, "freegfw_engine_restarts_total", "Number of times a running engine was restarted.", "counter")
+	writeSample(w, "freegfw_engine_restarts_total", "", float64(restarts))
+
+	var links []models.Link
+	database.DB.Find(&links)
+	writeHeader(w, "freegfw_link_sync_success", "Whether the last sync with the linked node succeeded.", "gauge")
+	for _, l := range links {
+		ok := 0.0
+		if l.LastSyncStatus == "success" {
+			ok = 1
+		}
+		writeSample(w, "freegfw_link_sync_success", linkLabels(l), ok)
+	}
+	writeHeader(w, "freegfw_link_last_sync_timestamp_seconds", "Unix time of the last sync attempt.", "gauge")
+	for _, l := range links {
+		if l.LastSyncAt != nil {
+			writeSample(w, "freegfw_link_last_sync_timestamp_seconds", linkLabels(l), float64(*l.LastSyncAt))
+		}
+	}
+	writeHeader(w, "freegfw_link_sync_duration_seconds", "Duration of the last sync request.", "gauge")
+	for _, l := range links {
+		if d, ok := latency[l.ID]; ok {
+			writeSample(w, "freegfw_link_sync_duration_seconds", linkLabels(l), d.Seconds())
+		}
+	}
+
+	if notAfter, err := CertificateNotAfter(); err == nil {
+		writeHeader(w, "freegfw_certificate_expiry_timestamp_seconds", "Unix time at which the TLS certificate expires.", "gauge")
+		writeSample(w, "freegfw_certificate_expiry_timestamp_seconds", "", float64(notAfter.Unix()))
+	}
+
+	writeHeader(w, "freegfw_db_flush_errors_total", "Failed writes of traffic counters to SQLite.", "counter")
+	writeSample(w, "freegfw_db_flush_errors_total", "", float64(flushErrors))
+}
+
+func linkLabels(l models.Link) string {
+	name := ""
+	if l.Name != nil {
+		name = *l.Name
+	}
+	return labels("link_id", fmt.Sprintf("%d", l.ID), "name", name)
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeSample(w io.Writer, name, labelStr string, value float64) {
+	fmt.Fprintf(w, "%s%s %v\n", name, labelStr, value)
+}
+
+// labels formats key/value pairs as a Prometheus label set.
+func labels(kv ...string) string {
+	var parts []string
+	for i := 0; i+1 < len(kv); i += 2 {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, kv[i], labelEscaper.Replace(kv[i+1])))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
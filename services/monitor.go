@@ -1,18 +1,23 @@
 package services
 
 import (
-	"log"
+	"log/slog"
 	"time"
 )
 
 func StartMonitoring() {
+	go accounting.run()
+	go destinations.run()
+	go accessLog.run()
+	go subscriptions.run()
+	go system.run()
 	go monitorDirectly()
 }
 
 func monitorDirectly() {
 	defer func() {
 		if r := recover(); r != nil {
-			log.Println("Recovered from monitorDirectly panic:", r)
+			slog.Error("Recovered from monitorDirectly panic", "panic", r)
 			time.Sleep(3 * time.Second)
 			go monitorDirectly()
 		}
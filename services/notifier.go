@@ -0,0 +1,283 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+
+	"freegfw/database"
+	"freegfw/models"
+)
+
+// Event names passed to Notify.
+const (
+	EventCertRenewalFailed = "cert_renewal_failed"
+	EventEngineStartFailed = "engine_start_failed"
+	EventLinkSyncFailed    = "link_sync_failed"
+	EventWatchdogRestart   = "watchdog_restart"
+	EventQuotaThreshold    = "quota_threshold"
+	EventSubscriptionShare = "subscription_shared"
+	EventTest              = "test"
+)
+
+// notifyCooldown suppresses repeats of the same event and message, e.g. a
+// certificate renewal that fails on every hourly check.
+const notifyCooldown = time.Hour
+
+type Notification struct {
+	Event   string    `json:"event"`
+	Node    string    `json:"node"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+// NotificationChannel delivers notifications to one destination.
+type NotificationChannel interface {
+	Name() string
+	Send(n Notification) error
+}
+
+// NotificationConfig is stored as JSON in the "notifications" setting.
+type NotificationConfig struct {
+	Webhook  WebhookConfig  `json:"webhook"`
+	SMTP     SMTPConfig     `json:"smtp"`
+	Telegram TelegramConfig `json:"telegram"`
+	// QuotaThresholds are percentages of a user's quota that trigger an alert.
+	QuotaThresholds []int `json:"quota_thresholds"`
+}
+
+type WebhookConfig struct {
+	Enabled bool   `json:"enabled"`
+	URL     string `json:"url"`
+}
+
+type SMTPConfig struct {
+	Enabled  bool     `json:"enabled"`
+	Host     string   `json:"host"`
+	Port     int      `json:"port"`
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+}
+
+type TelegramConfig struct {
+	Enabled  bool   `json:"enabled"`
+	BotToken string `json:"bot_token"`
+	ChatID   string `json:"chat_id"`
+	// APIURL overrides https://api.telegram.org, e.g. for a local Bot API server.
+	APIURL string `json:"api_url"`
+}
+
+// NotificationSecretMask replaces the SMTP password and Telegram bot token
+// when the configuration is shown. Saving it back keeps the stored value.
+const NotificationSecretMask = "********"
+
+var notifyClient = &http.Client{Timeout: 10 * time.Second}
+
+var (
+	notifyMu   sync.Mutex
+	notifySent = make(map[string]time.Time)
+)
+
+func LoadNotificationConfig() NotificationConfig {
+	cfg := NotificationConfig{QuotaThresholds: []int{80, 100}}
+	var s models.Setting
+	database.DB.Where("key = ?", "notifications").Limit(1).Find(&s)
+	if len(s.Value) > 0 {
+		if err := json.Unmarshal(s.Value, &cfg); err != nil {
+			slog.Warn("Invalid notifications setting", "err", err)
+		}
+	}
+	return cfg
+}
+
+func SaveNotificationConfig(cfg NotificationConfig) error {
+	val, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	saveSetting("notifications", val)
+	return nil
+}
+
+// Masked returns the configuration with its credentials replaced by
+// NotificationSecretMask.
+func (cfg NotificationConfig) Masked() NotificationConfig {
+	if cfg.SMTP.Password != "" {
+		cfg.SMTP.Password = NotificationSecretMask
+	}
+	if cfg.Telegram.BotToken != "" {
+		cfg.Telegram.BotToken = NotificationSecretMask
+	}
+	return cfg
+}
+
+// KeepSecrets puts back the credentials of stored that cfg has masked.
+func (cfg *NotificationConfig) KeepSecrets(stored NotificationConfig) {
+	if cfg.SMTP.Password == NotificationSecretMask {
+		cfg.SMTP.Password = stored.SMTP.Password
+	}
+	if cfg.Telegram.BotToken == NotificationSecretMask {
+		cfg.Telegram.BotToken = stored.Telegram.BotToken
+	}
+}
+
+// Channels returns the enabled channels of the configuration.
+func (cfg NotificationConfig) Channels() []NotificationChannel {
+	var channels []NotificationChannel
+	if cfg.Webhook.Enabled && cfg.Webhook.URL != "" {
+		channels = append(channels, &webhookChannel{cfg.Webhook})
+	}
+	if cfg.SMTP.Enabled && cfg.SMTP.Host != "" && len(cfg.SMTP.To) > 0 {
+		channels = append(channels, &smtpChannel{cfg.SMTP})
+	}
+	if cfg.Telegram.Enabled && cfg.Telegram.BotToken != "" && cfg.Telegram.ChatID != "" {
+		channels = append(channels, &telegramChannel{cfg.Telegram})
+	}
+	return channels
+}
+
+// Notify sends an operational alert to every enabled channel in the
+// background. The same event and message is sent at most once per cooldown.
+func Notify(event, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	slog.Warn("Notification", "event", event, "message", message)
+
+	key := event + "\x00" + message
+	notifyMu.Lock()
+	if last, ok := notifySent[key]; ok && time.Since(last) < notifyCooldown {
+		notifyMu.Unlock()
+		return
+	}
+	for k, last := range notifySent {
+		if time.Since(last) >= notifyCooldown {
+			delete(notifySent, k)
+		}
+	}
+	notifySent[key] = time.Now()
+	notifyMu.Unlock()
+
+	go func() {
+		n := newNotification(event, message)
+		for _, ch := range LoadNotificationConfig().Channels() {
+			if err := ch.Send(n); err != nil {
+				slog.Error("Notification channel failed", "channel", ch.Name(), "event", event, "err", err)
+			}
+		}
+	}()
+}
+
+// SendTestNotification delivers a test message synchronously and returns
+// the error of each channel, keyed by channel name (nil on success).
+func SendTestNotification(cfg NotificationConfig) map[string]error {
+	n := newNotification(EventTest, "This is a test notification.")
+	res := make(map[string]error)
+	for _, ch := range cfg.Channels() {
+		res[ch.Name()] = ch.Send(n)
+	}
+	return res
+}
+
+func newNotification(event, message string) Notification {
+	var t models.Setting
+	database.DB.Where("key = ?", "title").Limit(1).Find(&t)
+	node := "FreeGFW"
+	if len(t.Value) > 0 {
+		json.Unmarshal(t.Value, &node)
+	}
+	return Notification{Event: event, Node: node, Message: message, Time: time.Now()}
+}
+
+func (n Notification) text() string {
+	return fmt.Sprintf("[%s] %s: %s", n.Node, n.Event, n.Message)
+}
+
+type webhookChannel struct {
+	cfg WebhookConfig
+}
+
+func (w *webhookChannel) Name() string { return "webhook" }
+
+func (w *webhookChannel) Send(n Notification) error {
+	body, _ := json.Marshal(n)
+	resp, err := notifyClient.Post(w.cfg.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("bad status: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+type smtpChannel struct {
+	cfg SMTPConfig
+}
+
+func (s *smtpChannel) Name() string { return "smtp" }
+
+func (s *smtpChannel) Send(n Notification) error {
+	port := s.cfg.Port
+	if port == 0 {
+		port = 587
+	}
+	addr := fmt.Sprintf("%s:%d", s.cfg.Host, port)
+
+	from := s.cfg.From
+	if from == "" {
+		from = s.cfg.Username
+	}
+
+	var auth smtp.Auth
+	if s.cfg.Username != "" {
+		auth = smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, s.cfg.Host)
+	}
+
+	msg := "From: " + from + "\r\n" +
+		"To: " + strings.Join(s.cfg.To, ", ") + "\r\n" +
+		"Subject: [" + n.Node + "] " + n.Event + "\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" + n.text() + "\r\n"
+
+	return smtp.SendMail(addr, auth, from, s.cfg.To, []byte(msg))
+}
+
+type telegramChannel struct {
+	cfg TelegramConfig
+}
+
+func (t *telegramChannel) Name() string { return "telegram" }
+
+func (t *telegramChannel) Send(n Notification) error {
+	apiURL := strings.TrimRight(t.cfg.APIURL, "/")
+	if apiURL == "" {
+		apiURL = "https://api.telegram.org"
+	}
+	body, _ := json.Marshal(map[string]string{
+		"chat_id": t.cfg.ChatID,
+		"text":    n.text(),
+	})
+	resp, err := notifyClient.Post(apiURL+"/bot"+t.cfg.BotToken+"/sendMessage", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var res struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+	}
+	json.NewDecoder(resp.Body).Decode(&res)
+	if resp.StatusCode != http.StatusOK || !res.OK {
+		return fmt.Errorf("telegram api error (%d): %s", resp.StatusCode, res.Description)
+	}
+	return nil
+}
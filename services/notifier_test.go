@@ -0,0 +1,218 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testNotification() Notification {
+	return Notification{
+		Event:   EventTest,
+		Node:    "node-1",
+		Message: "This is a test notification.",
+		Time:    time.Unix(1700000000, 0).UTC(),
+	}
+}
+
+func TestWebhookChannel(t *testing.T) {
+	var got Notification
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("got %s with Content-Type %q", r.Method, r.Header.Get("Content-Type"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer srv.Close()
+
+	ch := &webhookChannel{WebhookConfig{Enabled: true, URL: srv.URL}}
+	if err := ch.Send(testNotification()); err != nil {
+		t.Fatal(err)
+	}
+	if want := testNotification(); !got.Time.Equal(want.Time) || got.Event != want.Event || got.Node != want.Node || got.Message != want.Message {
+		t.Errorf("webhook received %+v, want %+v", got, want)
+	}
+}
+
+func TestWebhookChannelError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	ch := &webhookChannel{WebhookConfig{Enabled: true, URL: srv.URL}}
+	if err := ch.Send(testNotification()); err == nil {
+		t.Error("Send succeeded on a 500 response")
+	}
+}
+
+func TestTelegramChannel(t *testing.T) {
+	var path string
+	var body map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["chat_id"] == "denied" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"ok":false,"description":"Forbidden: bot was blocked by the user"}`))
+			return
+		}
+		w.Write([]byte(`{"ok":true,"result":{}}`))
+	}))
+	defer srv.Close()
+
+	ch := &telegramChannel{TelegramConfig{Enabled: true, BotToken: "123:abc", ChatID: "42", APIURL: srv.URL + "/"}}
+	if err := ch.Send(testNotification()); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/bot123:abc/sendMessage" {
+		t.Errorf("path = %q", path)
+	}
+	if body["chat_id"] != "42" || body["text"] != testNotification().text() {
+		t.Errorf("body = %v", body)
+	}
+
+	ch.cfg.ChatID = "denied"
+	if err := ch.Send(testNotification()); err == nil || !strings.Contains(err.Error(), "blocked") {
+		t.Errorf("err = %v, want the API description", err)
+	}
+}
+
+// smtpStub accepts one message over plain SMTP with AUTH PLAIN.
+type smtpStub struct {
+	addr string
+	auth string
+	from string
+	to   []string
+	data string
+	done chan struct{}
+}
+
+func newSMTPStub(t *testing.T) *smtpStub {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	s := &smtpStub{addr: l.Addr().String(), done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		tc := textproto.NewConn(conn)
+		tc.PrintfLine("220 stub ESMTP")
+		for {
+			line, err := tc.ReadLine()
+			if err != nil {
+				return
+			}
+			verb, arg, _ := strings.Cut(line, " ")
+			switch strings.ToUpper(verb) {
+			case "EHLO":
+				tc.PrintfLine("250-stub")
+				tc.PrintfLine("250 AUTH PLAIN")
+			case "AUTH":
+				s.auth = strings.TrimPrefix(arg, "PLAIN ")
+				tc.PrintfLine("235 ok")
+			case "MAIL":
+				s.from = arg
+				tc.PrintfLine("250 ok")
+			case "RCPT":
+				s.to = append(s.to, arg)
+				tc.PrintfLine("250 ok")
+			case "DATA":
+				tc.PrintfLine("354 go ahead")
+				b, err := tc.ReadDotBytes()
+				if err != nil {
+					return
+				}
+				s.data = string(b)
+				tc.PrintfLine("250 queued")
+			case "QUIT":
+				tc.PrintfLine("221 bye")
+				return
+			default:
+				tc.PrintfLine("502 not implemented")
+			}
+		}
+	}()
+	return s
+}
+
+func TestSMTPChannel(t *testing.T) {
+	stub := newSMTPStub(t)
+	host, port, _ := net.SplitHostPort(stub.addr)
+	p, _ := strconv.Atoi(port)
+
+	ch := &smtpChannel{SMTPConfig{
+		Enabled:  true,
+		Host:     host,
+		Port:     p,
+		Username: "alerts@example.com",
+		Password: "hunter2",
+		To:       []string{"admin@example.com", "ops@example.com"},
+	}}
+	if err := ch.Send(testNotification()); err != nil {
+		t.Fatal(err)
+	}
+	<-stub.done
+
+	auth, _ := base64.StdEncoding.DecodeString(stub.auth)
+	if string(auth) != "\x00alerts@example.com\x00hunter2" {
+		t.Errorf("auth = %q", auth)
+	}
+	if stub.from != "FROM:<alerts@example.com>" {
+		t.Errorf("from = %q", stub.from)
+	}
+	if len(stub.to) != 2 || stub.to[1] != "TO:<ops@example.com>" {
+		t.Errorf("to = %q", stub.to)
+	}
+	for _, want := range []string{
+		"Subject: [node-1] test\n",
+		"To: admin@example.com, ops@example.com\n",
+		testNotification().text(),
+	} {
+		if !strings.Contains(stub.data, want) {
+			t.Errorf("message does not contain %q:\n%s", want, stub.data)
+		}
+	}
+}
+
+func TestNotificationConfigMask(t *testing.T) {
+	stored := NotificationConfig{
+		SMTP:     SMTPConfig{Password: "hunter2"},
+		Telegram: TelegramConfig{BotToken: "123:abc"},
+	}
+	masked := stored.Masked()
+	if masked.SMTP.Password != NotificationSecretMask || masked.Telegram.BotToken != NotificationSecretMask {
+		t.Fatalf("credentials not masked: %+v", masked)
+	}
+	if (NotificationConfig{}).Masked().SMTP.Password != "" {
+		t.Error("empty password masked")
+	}
+
+	masked.KeepSecrets(stored)
+	if masked.SMTP.Password != "hunter2" || masked.Telegram.BotToken != "123:abc" {
+		t.Errorf("stored credentials not kept: %+v", masked)
+	}
+
+	changed := NotificationConfig{SMTP: SMTPConfig{Password: "new"}}
+	changed.KeepSecrets(stored)
+	if changed.SMTP.Password != "new" || changed.Telegram.BotToken != "" {
+		t.Errorf("new credentials overwritten: %+v", changed)
+	}
+}
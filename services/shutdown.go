@@ -0,0 +1,27 @@
+package services
+
+import (
+	"log/slog"
+
+	"freegfw/database"
+)
+
+// Shutdown stops the running engine and persists everything still held in
+// memory. The engine is closed first so that connections are torn down and
+// their final bytes are counted before the last flush.
+func Shutdown() {
+	if coreInstance != nil {
+		slog.Info("Stopping engine", "engine", coreInstance.CurrentEngine)
+		coreInstance.Kill()
+	}
+
+	if err := accounting.Flush(); err != nil {
+		slog.Error("Failed to flush traffic on shutdown", "err", err)
+	}
+	accessLog.Flush()
+
+	if err := database.Close(); err != nil {
+		slog.Error("Failed to close database", "err", err)
+	}
+	slog.Info("Shutdown complete")
+}
@@ -2,7 +2,7 @@ package services
 
 import (
 	"encoding/json"
-	"log"
+	"log/slog"
 	"strings"
 	"time"
 
@@ -98,10 +98,10 @@ func (c *CoreService) refreshSingbox(server map[string]interface{}, templateName
 
 		if warpAccount == nil || warpAccount.PrivateKey == "" {
 			// Auto register
-			log.Println("Auto-registering Cloudflare WARP account...")
+			slog.Info("Auto-registering Cloudflare WARP account", "engine", "sing-box")
 			acc, err := RegisterWarp()
 			if err != nil {
-				log.Println("Failed to register warp:", err)
+				slog.Error("Failed to register warp", "err", err)
 				// fallback to direct
 				outbounds = append(outbounds, map[string]interface{}{"type": "direct", "tag": "direct"})
 			} else {
@@ -135,6 +135,10 @@ func (c *CoreService) refreshSingbox(server map[string]interface{}, templateName
 	}
 
 	config := map[string]interface{}{
+		"log": map[string]interface{}{
+			"level":     LoadLogConfig().SingboxLevel,
+			"timestamp": true,
+		},
 		"inbounds": []map[string]interface{}{server},
 		"outbounds": outbounds,
 		"experimental": map[string]interface{}{
@@ -155,15 +159,6 @@ func (c *CoreService) refreshSingbox(server map[string]interface{}, templateName
 }
 
 func monitorSingboxLoop() {
-
-
-
-	// Map connection ID to usage {Up, Down}
-	connStats := make(map[string]struct{ Up, Down uint64 })
-	// User traffic accumulator: InboundUser -> {Up, Down}
-	userTraffic := make(map[string]struct{ Up, Down int64 })
-	var flushCounter int
-
 	for {
 		if coreInstance != nil && coreInstance.CurrentEngine != "singbox" {
 			return
@@ -183,7 +178,7 @@ func monitorSingboxLoop() {
 		}
 
 		// Initialize last values
-		lastUp, lastDown := tm.Total()
+		lastUp, lastDown := accounting.totals()
 
 		// 1 second interval
 		ticker := time.NewTicker(1 * time.Second)
@@ -193,145 +188,43 @@ func monitorSingboxLoop() {
 				break
 			}
 
-			// Get current totals
-			currUp, currDown := tm.Total()
-
-			// Calculate diff (bytes per second)
-			// Using int64 to handle potential resets/overflows gracefully
-			diffUp := int64(currUp) - int64(lastUp)
-			diffDown := int64(currDown) - int64(lastDown)
-
-			// Handle potential counter resets
-			if diffUp < 0 {
-				diffUp = 0
-			}
-			if diffDown < 0 {
-				diffDown = 0
-			}
-
-			// Update last values
+			// Per-user bytes are counted by the StatisticsTracker wrappers and
+			// flushed by the accounting pipeline; this loop only reports.
+			currUp, currDown := accounting.totals()
+			diffUp := currUp - lastUp
+			diffDown := currDown - lastDown
 			lastUp = currUp
 			lastDown = currDown
 
-			// Speed (Mbps)
-			speed := map[string]float64{
-				"up":   float64(diffUp) * 8 / 1000000,
-				"down": float64(diffDown) * 8 / 1000000,
-			}
+			metrics.setSpeed(diffUp, diffDown)
 
 			if Hub != nil {
-				// Broadcast Speed
-				Hub.Broadcast("speed", speed)
+				// Speed (Mbps)
+				Hub.Broadcast("speed", map[string]float64{
+					"up":   float64(diffUp) * 8 / 1000000,
+					"down": float64(diffDown) * 8 / 1000000,
+				})
+
+				Hub.Broadcast("traffic", map[string]int64{
+					"up":   currUp,
+					"down": currDown,
+				})
+			}
 
-				// Total Traffic
-				total := map[string]int64{
-					"up":   int64(currUp),
-					"down": int64(currDown),
-				}
-				Hub.Broadcast("traffic", total)
-
-				// Connections Snapshot
-				snapshot := tm.Snapshot()
-
-				// Watchdog for Goroutine/Connection Leaks
-				if len(snapshot.Connections) > 8000 {
-					log.Printf("[Watchdog] High connection count (%d) detected, possible leak. Restarting engine...\n", len(snapshot.Connections))
-					go func() {
-						coreInstance.Restart()
-					}()
-					return // Exit current loop
-				}
+			// Connections Snapshot
+			snapshot := tm.Snapshot()
 
+			if Hub != nil {
 				Hub.Broadcast("connections", snapshot)
+			}
 
-				// Process Per-User Traffic natively
-				// Check for single user fallback ONCE per tick
-				var defaultUsername string
-				var userCount int64
-				database.DB.Model(&models.User{}).Count(&userCount)
-				if userCount == 1 {
-					var u models.User
-					if err := database.DB.First(&u).Error; err == nil {
-						defaultUsername = u.Username
-					}
-				}
-
-				currentConns := make(map[string]bool)
-				for _, t := range snapshot.Connections {
-					tm := t.Metadata()
-					if tm == nil {
-						continue
-					}
-					id := tm.ID.String()
-					if id == "" {
-						continue
-					}
-					currentConns[id] = true
-
-					cUp := uint64(tm.Upload.Load())
-					cDown := uint64(tm.Download.Load())
-
-					prev, exists := connStats[id]
-					if !exists {
-						prev = struct{ Up, Down uint64 }{0, 0}
-					}
-
-					// Calculate delta for this connection
-					dUp := int64(cUp) - int64(prev.Up)
-					dDown := int64(cDown) - int64(prev.Down)
-
-					if dUp < 0 {
-						dUp = 0
-					}
-					if dDown < 0 {
-						dDown = 0
-					}
-
-					connStats[id] = struct{ Up, Down uint64 }{cUp, cDown}
-
-					// Accumulate if user is identified
-					inboundUser := tm.Metadata.User
-
-					// Fallback if not identified
-					if inboundUser == "" && defaultUsername != "" {
-						inboundUser = defaultUsername
-					}
-
-					if inboundUser != "" {
-						uT := userTraffic[inboundUser]
-						uT.Up += dUp
-						uT.Down += dDown
-						userTraffic[inboundUser] = uT
-					}
-				}
-
-				// Cleanup stale connection stats
-				for id := range connStats {
-					if !currentConns[id] {
-						delete(connStats, id)
-					}
-				}
-
-				// Periodically flush to user DB
-				flushCounter++
-				if flushCounter >= 10 { // Every 10 seconds
-					for username, traffic := range userTraffic {
-						if traffic.Up > 0 || traffic.Down > 0 {
-							// Find user by Username or UUID and update traffic
-							var user models.User
-							if err := database.DB.Where("uuid = ?", username).Or("username = ?", username).First(&user).Error; err == nil {
-								database.DB.Model(&user).Updates(map[string]interface{}{
-									"upload":   user.Upload + traffic.Up,
-									"download": user.Download + traffic.Down,
-								})
-							}
-						}
-					}
-					// Reset accumulator
-					userTraffic = make(map[string]struct{ Up, Down int64 })
-					flushCounter = 0
+			userConns := make(map[string]int)
+			for _, t := range snapshot.Connections {
+				if md := t.Metadata(); md != nil && md.Metadata.User != "" {
+					userConns[md.Metadata.User]++
 				}
 			}
+			metrics.setUserConnections(userConns)
 		}
 
 		ticker.Stop()
@@ -3,7 +3,10 @@ package services
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -16,10 +19,14 @@ type SSEHub struct {
 	mutex      sync.Mutex
 }
 
-var Hub *SSEHub
+// Hub carries the traffic monitoring events, LogHub the live log records.
+var (
+	Hub    *SSEHub
+	LogHub *SSEHub
+)
 
-func InitSSEHub() {
-	Hub = &SSEHub{
+func newSSEHub() *SSEHub {
+	h := &SSEHub{
 		clients:    make(map[chan string]bool),
 		register:   make(chan chan string),
 		unregister: make(chan chan string),
@@ -27,11 +34,18 @@ func InitSSEHub() {
 		// The buffer is sufficient to absorb transient pressure, preventing Broadcast() from blocking the ticker goroutine.
 		broadcast: make(chan []byte, 64),
 	}
-	go Hub.run()
+	go h.run()
+	return h
+}
+
+func InitSSEHub() {
+	Hub = newSSEHub()
+	LogHub = newSSEHub()
 	go func() {
 		for {
 			time.Sleep(10 * time.Second)
 			Hub.Broadcast("ping", "pong")
+			LogHub.Broadcast("ping", "pong")
 		}
 	}()
 }
@@ -72,8 +86,10 @@ func (h *SSEHub) run() {
 // connected and the hub's run() loop is busy), the message is silently
 // dropped rather than blocking the caller's goroutine.
 func (h *SSEHub) Broadcast(event string, data interface{}) {
-	payload, _ := json.Marshal(data)
-	msg := fmt.Sprintf("event: %s\ndata: %s\n\n", event, payload)
+	h.send(sseEvent(0, event, data))
+}
+
+func (h *SSEHub) send(msg string) {
 	select {
 	case h.broadcast <- []byte(msg):
 	default:
@@ -81,21 +97,63 @@ func (h *SSEHub) Broadcast(event string, data interface{}) {
 	}
 }
 
+// sseEvent formats one SSE event, with an id line unless id is 0.
+func sseEvent(id uint64, event string, data interface{}) string {
+	payload, _ := json.Marshal(data)
+	msg := fmt.Sprintf("event: %s\ndata: %s\n\n", event, payload)
+	if id != 0 {
+		msg = "id: " + strconv.FormatUint(id, 10) + "\n" + msg
+	}
+	return msg
+}
+
+// sseEventID returns the id of an event formatted by sseEvent, or 0.
+func sseEventID(msg string) uint64 {
+	line, _, _ := strings.Cut(msg, "\n")
+	id, _ := strconv.ParseUint(strings.TrimPrefix(line, "id: "), 10, 64)
+	return id
+}
+
 func ServeSSE(w http.ResponseWriter, r *http.Request) {
+	Hub.serve(w, r, nil)
+}
+
+// ServeLogSSE replays the buffered log records and then streams new ones.
+func ServeLogSSE(w http.ResponseWriter, r *http.Request) {
+	LogHub.serve(w, r, func(w io.Writer) uint64 {
+		var last uint64
+		for _, entry := range recentLogs.list() {
+			io.WriteString(w, sseEvent(entry.seq, "log", entry))
+			last = entry.seq
+		}
+		return last
+	})
+}
+
+// serve streams the hub's events to one client. replay, if set, writes the
+// initial backlog after the connected event and returns the id of the last
+// event it wrote. The client is registered first so nothing logged during
+// the replay is missed, and live events the replay already covered are
+// skipped.
+func (h *SSEHub) serve(w http.ResponseWriter, r *http.Request, replay func(w io.Writer) uint64) {
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
 	clientChan := make(chan string, 100)
-	Hub.register <- clientChan
+	h.register <- clientChan
 
 	// Send initial connection event
 	fmt.Fprintf(w, "event: connected\ndata: true\n\n")
+	var replayed uint64
+	if replay != nil {
+		replayed = replay(w)
+	}
 	w.(http.Flusher).Flush()
 
 	defer func() {
-		Hub.unregister <- clientChan
+		h.unregister <- clientChan
 	}()
 
 	for {
@@ -104,6 +162,9 @@ func ServeSSE(w http.ResponseWriter, r *http.Request) {
 			if !ok {
 				return
 			}
+			if id := sseEventID(msg); id != 0 && id <= replayed {
+				continue
+			}
 			if _, err := fmt.Fprintf(w, "%s", msg); err != nil {
 				return
 			}
@@ -0,0 +1,35 @@
+package services
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSSEReplayNotRepeated(t *testing.T) {
+	h := newSSEHub()
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	r := httptest.NewRequest("GET", "/stream/logs", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+
+	h.serve(w, r, func(w io.Writer) uint64 {
+		// Records 2 and 3 are logged while 1 and 2 are replayed
+		h.send(sseEvent(2, "log", "two"))
+		h.send(sseEvent(3, "log", "three"))
+		h.Broadcast("ping", "pong")
+		io.WriteString(w, sseEvent(1, "log", "one"))
+		io.WriteString(w, sseEvent(2, "log", "two"))
+		return 2
+	})
+
+	body := w.Body.String()
+	for _, want := range []string{`"one"`, `"two"`, `"three"`, `"pong"`} {
+		if n := strings.Count(body, want); n != 1 {
+			t.Errorf("%s sent %d times:\n%s", want, n, body)
+		}
+	}
+}
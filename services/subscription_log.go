@@ -0,0 +1,223 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"freegfw/database"
+	"freegfw/models"
+)
+
+// subscriptionRateWindow is the window RateLimit is counted over.
+const subscriptionRateWindow = time.Hour
+
+// SubscriptionConfig is stored as JSON in the "subscription" setting.
+type SubscriptionConfig struct {
+	// RateLimit is the most fetches of one subscription per hour; 0
+	// disables the limit.
+	RateLimit int `json:"rate_limit"`
+	// AlertIPs raises an alert when one subscription is fetched from at
+	// least this many distinct IPs within AlertWindow hours; 0 disables it.
+	AlertIPs    int `json:"alert_ips"`
+	AlertWindow int `json:"alert_window"`
+	// RetentionDays is how long fetches are kept; 0 keeps them forever.
+	RetentionDays int `json:"retention_days"`
+}
+
+// SubscriptionUserStats summarizes the fetches of one user's subscription.
+type SubscriptionUserStats struct {
+	UserID          uint      `json:"userId"`
+	Username        string    `json:"username"`
+	LastFetchedAt   time.Time `json:"lastFetchedAt"`
+	Fetches         int64     `json:"fetches"`
+	DistinctIPs     int64     `json:"distinctIps"`
+	DistinctClients int64     `json:"distinctClients"`
+}
+
+// subscriptionTracker records subscription fetches and enforces the
+// per-subscription rate limit.
+type subscriptionTracker struct {
+	cfg atomic.Pointer[SubscriptionConfig]
+
+	mu     sync.Mutex
+	recent map[uint][]time.Time // fetch times within subscriptionRateWindow
+}
+
+var subscriptions = &subscriptionTracker{recent: make(map[uint][]time.Time)}
+
+func LoadSubscriptionConfig() SubscriptionConfig {
+	cfg := SubscriptionConfig{AlertIPs: 5, AlertWindow: 24, RetentionDays: 30}
+	var s models.Setting
+	database.DB.Where("key = ?", "subscription").Limit(1).Find(&s)
+	if len(s.Value) > 0 {
+		if err := json.Unmarshal(s.Value, &cfg); err != nil {
+			slog.Warn("Invalid subscription setting", "err", err)
+		}
+	}
+	return cfg
+}
+
+func SaveSubscriptionConfig(cfg SubscriptionConfig) error {
+	if cfg.RateLimit < 0 || cfg.AlertIPs < 0 || cfg.AlertWindow < 0 || cfg.RetentionDays < 0 {
+		return errors.New("values must not be negative")
+	}
+	if cfg.AlertIPs > 0 && cfg.AlertWindow == 0 {
+		return errors.New("alert_window is required when alert_ips is set")
+	}
+	val, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	saveSetting("subscription", val)
+	subscriptions.cfg.Store(&cfg)
+	return nil
+}
+
+func (t *subscriptionTracker) config() SubscriptionConfig {
+	if cfg := t.cfg.Load(); cfg != nil {
+		return *cfg
+	}
+	cfg := LoadSubscriptionConfig()
+	t.cfg.Store(&cfg)
+	return cfg
+}
+
+// AllowSubscriptionFetch reports whether the user's subscription may be
+// served now, counting the fetch against the rate limit if so.
+func AllowSubscriptionFetch(userID uint) bool {
+	return subscriptions.allow(userID, time.Now())
+}
+
+func (t *subscriptionTracker) allow(userID uint, now time.Time) bool {
+	limit := t.config().RateLimit
+	if limit <= 0 {
+		return true
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	cutoff := now.Add(-subscriptionRateWindow)
+	times := t.recent[userID]
+	for len(times) > 0 && times[0].Before(cutoff) {
+		times = times[1:]
+	}
+	if len(times) >= limit {
+		t.recent[userID] = times
+		return false
+	}
+	t.recent[userID] = append(times, now)
+	return true
+}
+
+// RecordSubscriptionFetch stores one fetch of the user's subscription and
+// alerts when the subscription looks shared.
+func RecordSubscriptionFetch(user models.User, ip, userAgent, format string, status int) {
+	fetch := models.SubscriptionFetch{
+		UserID:    user.ID,
+		IP:        ip,
+		UserAgent: userAgent,
+		Format:    format,
+		Status:    status,
+	}
+	if err := database.DB.Create(&fetch).Error; err != nil {
+		slog.Error("Failed to record subscription fetch", "user", user.Username, "err", err)
+		return
+	}
+
+	cfg := subscriptions.config()
+	if cfg.AlertIPs <= 0 {
+		return
+	}
+	var ips int64
+	since := time.Now().Add(-time.Duration(cfg.AlertWindow) * time.Hour)
+	database.DB.Model(&models.SubscriptionFetch{}).
+		Where("user_id = ? AND created_at >= ?", user.ID, since).
+		Distinct("ip").Count(&ips)
+	if ips >= int64(cfg.AlertIPs) {
+		// The message only names the threshold so Notify's cooldown
+		// suppresses repeats while the count keeps growing.
+		Notify(EventSubscriptionShare, "Subscription of user %s was fetched from %d or more IPs in %d hours, it may be shared", user.Username, cfg.AlertIPs, cfg.AlertWindow)
+	}
+}
+
+// GetSubscriptionStats summarizes fetches since the given time per user,
+// most recently fetched first.
+func GetSubscriptionStats(since time.Time) ([]SubscriptionUserStats, error) {
+	var rows []struct {
+		SubscriptionUserStats
+		LastID uint
+	}
+	// SQLite returns MAX(created_at) as text, so the time of the last fetch
+	// is looked up by its ID instead.
+	err := database.DB.Model(&models.SubscriptionFetch{}).
+		Select("subscription_fetches.user_id, users.username, MAX(subscription_fetches.id) AS last_id, "+
+			"COUNT(*) AS fetches, COUNT(DISTINCT subscription_fetches.ip) AS distinct_ips, "+
+			"COUNT(DISTINCT subscription_fetches.user_agent) AS distinct_clients").
+		Joins("JOIN users ON users.id = subscription_fetches.user_id").
+		Where("subscription_fetches.created_at >= ?", since).
+		Group("subscription_fetches.user_id").
+		Order("last_id DESC").
+		Scan(&rows).Error
+	if err != nil || len(rows) == 0 {
+		return nil, err
+	}
+
+	ids := make([]uint, len(rows))
+	for i, r := range rows {
+		ids[i] = r.LastID
+	}
+	var last []models.SubscriptionFetch
+	if err := database.DB.Select("id", "created_at").Where("id IN ?", ids).Find(&last).Error; err != nil {
+		return nil, err
+	}
+	times := make(map[uint]time.Time, len(last))
+	for _, f := range last {
+		times[f.ID] = f.CreatedAt
+	}
+
+	stats := make([]SubscriptionUserStats, len(rows))
+	for i, r := range rows {
+		stats[i] = r.SubscriptionUserStats
+		stats[i].LastFetchedAt = times[r.LastID]
+	}
+	return stats, nil
+}
+
+func (t *subscriptionTracker) run() {
+	t.config()
+
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		t.cleanup()
+		<-ticker.C
+	}
+}
+
+// cleanup deletes fetches older than the retention period and forgets rate
+// limit state of idle subscriptions.
+func (t *subscriptionTracker) cleanup() {
+	cutoff := time.Now().Add(-subscriptionRateWindow)
+	t.mu.Lock()
+	for id, times := range t.recent {
+		if len(times) == 0 || times[len(times)-1].Before(cutoff) {
+			delete(t.recent, id)
+		}
+	}
+	t.mu.Unlock()
+
+	days := t.config().RetentionDays
+	if days <= 0 {
+		return
+	}
+	res := database.DB.Where("created_at < ?", time.Now().AddDate(0, 0, -days)).Delete(&models.SubscriptionFetch{})
+	if res.Error != nil {
+		slog.Error("Failed to clean up subscription fetches", "err", res.Error)
+	} else if res.RowsAffected > 0 {
+		slog.Info("Cleaned up subscription fetches", "rows", res.RowsAffected)
+	}
+}
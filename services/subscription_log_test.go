@@ -0,0 +1,44 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSubscriptionRateLimit(t *testing.T) {
+	tr := &subscriptionTracker{recent: make(map[uint][]time.Time)}
+	tr.cfg.Store(&SubscriptionConfig{RateLimit: 3})
+	start := time.Unix(1700000000, 0)
+
+	steps := []struct {
+		after time.Duration
+		user  uint
+		want  bool
+	}{
+		{0, 1, true},
+		{10 * time.Minute, 1, true},
+		{20 * time.Minute, 1, true},
+		{30 * time.Minute, 1, false},
+		{30 * time.Minute, 2, true}, // counted per user
+		{59 * time.Minute, 1, false},
+		{60 * time.Minute, 1, false}, // the first fetch is exactly an hour old
+		{61 * time.Minute, 1, true},  // and now it has left the window
+		{62 * time.Minute, 1, false},
+		{71 * time.Minute, 1, true}, // the rejected fetch was not counted
+		{81 * time.Minute, 1, true},
+		{82 * time.Minute, 1, false},
+		{3 * time.Hour, 1, true},
+	}
+	for _, s := range steps {
+		if got := tr.allow(s.user, start.Add(s.after)); got != s.want {
+			t.Errorf("user %d after %v: allow = %v, want %v", s.user, s.after, got, s.want)
+		}
+	}
+
+	tr.cfg.Store(&SubscriptionConfig{})
+	for i := 0; i < 10; i++ {
+		if !tr.allow(1, start.Add(3*time.Hour)) {
+			t.Fatal("fetch rejected without a rate limit")
+		}
+	}
+}
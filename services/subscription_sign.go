@@ -0,0 +1,136 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"freegfw/database"
+	"freegfw/models"
+)
+
+var (
+	ErrSubscriptionSignature = errors.New("invalid subscription signature")
+	ErrSubscriptionExpired   = errors.New("subscription link expired")
+)
+
+// SignedSubscription is a subscription link that works until Expires. Only
+// the link expires: the configs it serves carry the user's UUID, which keeps
+// working as a credential (and as /subscribe/<uuid>) until the user is
+// deleted.
+type SignedSubscription struct {
+	Token   string    `json:"token"`
+	Expires time.Time `json:"expires"`
+	Sig     string    `json:"sig"`
+}
+
+// Query returns the signature query string.
+func (s SignedSubscription) Query() string {
+	return "exp=" + strconv.FormatInt(s.Expires.Unix(), 10) + "&sig=" + s.Sig
+}
+
+// Path returns the subscription path including the signature.
+func (s SignedSubscription) Path() string {
+	return "/subscribe/" + s.Token + "?" + s.Query()
+}
+
+var subscriptionSecret struct {
+	sync.Mutex
+	key []byte
+}
+
+// subscriptionKey returns the node's signing secret, generating and storing
+// it on first use.
+func subscriptionKey() ([]byte, error) {
+	subscriptionSecret.Lock()
+	defer subscriptionSecret.Unlock()
+	if subscriptionSecret.key != nil {
+		return subscriptionSecret.key, nil
+	}
+
+	var s models.Setting
+	database.DB.Where("key = ?", "subscription_secret").Limit(1).Find(&s)
+	var encoded string
+	if len(s.Value) > 0 {
+		json.Unmarshal(s.Value, &encoded)
+	}
+	if key, err := hex.DecodeString(encoded); err == nil && len(key) >= 32 {
+		subscriptionSecret.key = key
+		return key, nil
+	}
+	return rotateSubscriptionKey()
+}
+
+// RotateSubscriptionSecret replaces the signing secret, invalidating every
+// signed subscription link issued so far.
+func RotateSubscriptionSecret() error {
+	subscriptionSecret.Lock()
+	defer subscriptionSecret.Unlock()
+	_, err := rotateSubscriptionKey()
+	return err
+}
+
+// rotateSubscriptionKey must be called with subscriptionSecret locked.
+func rotateSubscriptionKey() ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	val, _ := json.Marshal(hex.EncodeToString(key))
+	saveSetting("subscription_secret", val)
+	subscriptionSecret.key = key
+	return key, nil
+}
+
+func subscriptionSignature(key []byte, token string, exp int64) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(token + ":" + strconv.FormatInt(exp, 10)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// SignSubscription issues a subscription link for the user that expires at
+// the given time.
+func SignSubscription(userID uint, expires time.Time) (SignedSubscription, error) {
+	key, err := subscriptionKey()
+	if err != nil {
+		return SignedSubscription{}, err
+	}
+	token := strconv.FormatUint(uint64(userID), 10)
+	exp := expires.Unix()
+	return SignedSubscription{
+		Token:   token,
+		Expires: time.Unix(exp, 0),
+		Sig:     subscriptionSignature(key, token, exp),
+	}, nil
+}
+
+// VerifySubscription checks a signed subscription link and returns the ID
+// of the user it was issued for.
+func VerifySubscription(token, exp, sig string) (uint, SignedSubscription, error) {
+	expires, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return 0, SignedSubscription{}, ErrSubscriptionSignature
+	}
+	key, err := subscriptionKey()
+	if err != nil {
+		return 0, SignedSubscription{}, err
+	}
+	if !hmac.Equal([]byte(sig), []byte(subscriptionSignature(key, token, expires))) {
+		return 0, SignedSubscription{}, ErrSubscriptionSignature
+	}
+	if time.Now().Unix() >= expires {
+		return 0, SignedSubscription{}, ErrSubscriptionExpired
+	}
+	id, err := strconv.ParseUint(token, 10, 64)
+	if err != nil {
+		return 0, SignedSubscription{}, ErrSubscriptionSignature
+	}
+	return uint(id), SignedSubscription{Token: token, Expires: time.Unix(expires, 0), Sig: sig}, nil
+}
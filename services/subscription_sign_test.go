@@ -0,0 +1,78 @@
+package services
+
+import (
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"freegfw/database"
+)
+
+// testDB points the database at a fresh file for the test.
+func testDB(t *testing.T) {
+	t.Helper()
+	database.Connect(filepath.Join(t.TempDir(), "test.db"))
+	t.Cleanup(func() { database.Close() })
+}
+
+func TestVerifySubscription(t *testing.T) {
+	testDB(t)
+	subscriptionSecret.key = nil
+	t.Cleanup(func() { subscriptionSecret.key = nil })
+
+	sign := func(expires time.Time) SignedSubscription {
+		t.Helper()
+		s, err := SignSubscription(42, expires)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	verify := func(s SignedSubscription) error {
+		_, _, err := VerifySubscription(s.Token, strconv.FormatInt(s.Expires.Unix(), 10), s.Sig)
+		return err
+	}
+
+	valid := sign(time.Now().Add(time.Hour))
+	id, got, err := VerifySubscription(valid.Token, strconv.FormatInt(valid.Expires.Unix(), 10), valid.Sig)
+	if err != nil || id != 42 || !got.Expires.Equal(valid.Expires) {
+		t.Fatalf("VerifySubscription = %d, %+v, %v; want 42, %+v", id, got, err, valid)
+	}
+
+	if err := verify(sign(time.Now().Add(-time.Second))); err != ErrSubscriptionExpired {
+		t.Errorf("expired link: err = %v, want %v", err, ErrSubscriptionExpired)
+	}
+
+	tampered := []SignedSubscription{valid, valid, valid}
+	tampered[0].Token = "43"
+	tampered[1].Expires = valid.Expires.Add(24 * time.Hour)
+	tampered[2].Sig = valid.Sig[:len(valid.Sig)-1] + "A"
+	if tampered[2].Sig == valid.Sig {
+		tampered[2].Sig = valid.Sig[:len(valid.Sig)-1] + "B"
+	}
+	for _, s := range tampered {
+		if err := verify(s); err != ErrSubscriptionSignature {
+			t.Errorf("tampered link %+v: err = %v, want %v", s, err, ErrSubscriptionSignature)
+		}
+	}
+	if _, _, err := VerifySubscription(valid.Token, "soon", valid.Sig); err != ErrSubscriptionSignature {
+		t.Errorf("malformed expiry: err = %v, want %v", err, ErrSubscriptionSignature)
+	}
+
+	// The secret survives a restart, but not a rotation
+	subscriptionSecret.key = nil
+	if err := verify(valid); err != nil {
+		t.Errorf("after reloading the secret: err = %v", err)
+	}
+	if err := RotateSubscriptionSecret(); err != nil {
+		t.Fatal(err)
+	}
+	if err := verify(valid); err != ErrSubscriptionSignature {
+		t.Errorf("after rotating the secret: err = %v, want %v", err, ErrSubscriptionSignature)
+	}
+	subscriptionSecret.key = nil
+	if err := verify(valid); err != ErrSubscriptionSignature {
+		t.Errorf("after reloading the rotated secret: err = %v, want %v", err, ErrSubscriptionSignature)
+	}
+}
@@ -1,14 +1,16 @@
 package services
 
 import (
-	"bytes"
 	"encoding/json"
+	"fmt"
 	"freegfw/database"
 	"freegfw/models"
 	"io"
-	"log"
+	"log/slog"
+	"math/rand"
 	"net"
 	"net/http"
+	"sync"
 	"time"
 )
 
@@ -36,24 +38,115 @@ var syncHTTPClient = &http.Client{
 // from a malicious or misbehaving remote server.
 const maxResponseBodyBytes = 1 << 20 // 1 MiB
 
-func StartSyncLoop() {
-	for {
-		time.Sleep(1 * time.Second)
+// Links are synced when a peer pushes a change notification, and otherwise
+// polled every linkPollInterval as a fallback. Failing peers are retried
+// with jittered exponential backoff.
+const (
+	linkPollInterval = 5 * time.Minute
+	linkRetryMin     = 15 * time.Second
+	linkRetryMax     = 30 * time.Minute
+)
 
-		var links []models.Link
-		threshold := time.Now().Add(-1 * time.Minute).Unix()
+// linkScheduler decides when each link is synced next.
+type linkScheduler struct {
+	mu       sync.Mutex
+	next     map[uint]time.Time
+	failures map[uint]int
+	wake     chan struct{}
+}
 
-		if err := database.DB.Where("last_sync_at < ? OR last_sync_status = ?", threshold, "pending").Find(&links).Error; err != nil {
-			continue
+var linkSync = &linkScheduler{
+	next:     make(map[uint]time.Time),
+	failures: make(map[uint]int),
+	wake:     make(chan struct{}, 1),
+}
+
+// RequestLinkSync syncs the link as soon as possible, e.g. because its peer
+// announced a change.
+func RequestLinkSync(linkID uint) {
+	linkSync.mu.Lock()
+	linkSync.next[linkID] = time.Time{}
+	linkSync.mu.Unlock()
+	select {
+	case linkSync.wake <- struct{}{}:
+	default:
+	}
+}
+
+// due reports whether the link should be synced now. Links the scheduler
+// has not seen yet are due when pending or when their last sync is older
+// than the poll interval.
+func (s *linkScheduler) due(link *models.Link, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	next, ok := s.next[link.ID]
+	if !ok {
+		if link.LastSyncStatus == "pending" || link.LastSyncAt == nil {
+			return true
 		}
+		next = time.Unix(*link.LastSyncAt, 0).Add(linkPollInterval)
+		s.next[link.ID] = next
+	}
+	return !now.Before(next)
+}
 
-		if len(links) == 0 {
+// done schedules the next sync of a link after an attempt.
+func (s *linkScheduler) done(linkID uint, ok bool, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ok {
+		delete(s.failures, linkID)
+		s.next[linkID] = now.Add(linkPollInterval)
+		return
+	}
+	s.failures[linkID]++
+	backoff := linkRetryMin << min(s.failures[linkID]-1, 10)
+	if backoff > linkRetryMax {
+		backoff = linkRetryMax
+	}
+	// ±20% so peers that failed together do not retry in lockstep
+	jitter := time.Duration(rand.Int63n(int64(backoff)*2/5)) - backoff/5
+	s.next[linkID] = now.Add(backoff + jitter)
+}
+
+// wait returns how long until the earliest scheduled sync, forgetting
+// links that no longer exist.
+func (s *linkScheduler) wait(links []models.Link, now time.Time) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	exists := make(map[uint]bool, len(links))
+	for _, l := range links {
+		exists[l.ID] = true
+	}
+	wait := linkPollInterval
+	for id, next := range s.next {
+		if !exists[id] {
+			delete(s.next, id)
+			delete(s.failures, id)
 			continue
 		}
+		if d := next.Sub(now); d < wait {
+			wait = d
+		}
+	}
+	return max(wait, time.Second)
+}
+
+func StartSyncLoop() {
+	for {
+		var links []models.Link
+		if err := database.DB.Find(&links).Error; err != nil {
+			slog.Error("Failed to load links", "err", err)
+		}
 
 		change := false
 		for i := range links {
-			if syncOneLink(&links[i]) {
+			if !linkSync.due(&links[i], time.Now()) {
+				continue
+			}
+			changed, ok := syncOneLink(&links[i])
+			linkSync.done(links[i].ID, ok, time.Now())
+			if changed {
 				change = true
 			}
 		}
@@ -61,31 +154,56 @@ func StartSyncLoop() {
 		if change {
 			core := NewCoreService()
 			if err := core.Refresh(); err != nil {
-				log.Println("[Sync] Refresh failed, skipping Start:", err)
-				continue
+				slog.Error("Refresh after link sync failed, skipping start", "err", err)
+			} else {
+				core.Start()
 			}
-			core.Start()
+		}
+
+		select {
+		case <-time.After(linkSync.wait(links, time.Now())):
+		case <-linkSync.wake:
 		}
 	}
 }
 
 // syncOneLink performs a single sync request for one link record.
-// It returns true if the remote data changed (ETag mismatch → core should restart).
+// It returns changed if the remote data changed (ETag mismatch → core should
+// restart) and ok if the sync succeeded.
 // Extracting this into its own function ensures defer runs promptly after each
 // link is processed, preventing file-descriptor leaks from the old loop-level defer.
-func syncOneLink(link *models.Link) (changed bool) {
+func syncOneLink(link *models.Link) (changed, ok bool) {
+	// Links made before nodes had identity keys are not trusted until an
+	// admin confirms the peer's key
+	if link.PeerKey == "" {
+		notifyLinkFailure(link, ErrLinkKeyMissing.Error())
+		database.DB.Model(link).Updates(map[string]interface{}{
+			"last_sync_status": "failed",
+			"last_sync_at":     time.Now().Unix(),
+			"error":            ErrLinkKeyMissing.Error(),
+		})
+		return false, false
+	}
+
 	myLink, _ := GetMyLink(link.LocalCode)
 	payload := map[string]string{"link": myLink}
 	jsonData, _ := json.Marshal(payload)
 
-	resp, err := syncHTTPClient.Post(link.Link, "application/json", bytes.NewBuffer(jsonData))
+	start := time.Now()
+	req, nonce, err := NewLinkRequest(link.Link, jsonData)
+	var resp *http.Response
+	if err == nil {
+		resp, err = syncHTTPClient.Do(req)
+	}
+	metrics.setLinkLatency(link.ID, time.Since(start))
 	if err != nil {
+		notifyLinkFailure(link, err.Error())
 		database.DB.Model(link).Updates(map[string]interface{}{
 			"last_sync_status": "failed",
 			"last_sync_at":     time.Now().Unix(),
 			"error":            err.Error(),
 		})
-		return false
+		return false, false
 	}
 	// defer runs immediately when function returns, preventing leaks
 	defer resp.Body.Close()
@@ -94,14 +212,25 @@ func syncOneLink(link *models.Link) (changed bool) {
 	bodyReader := io.LimitReader(resp.Body, maxResponseBodyBytes)
 	body, err := io.ReadAll(bodyReader)
 	if err != nil {
-		log.Println("[Sync] Failed to read response body:", err)
-		return false
+		slog.Warn("Failed to read link sync response", "link", link.ID, "err", err)
+		return false, false
 	}
 
 	// Must read body to completion to allow connection reuse in the pool
 	// (discard remaining data manually if any remains after LimitReader)
 	io.Copy(io.Discard, resp.Body) //nolint:errcheck
 
+	// Nothing in an unsigned response is trusted, not even an error
+	if _, err := VerifyLinkResponse(resp, body, nonce, link.PeerKey); err != nil {
+		notifyLinkFailure(link, err.Error())
+		database.DB.Model(link).Updates(map[string]interface{}{
+			"last_sync_status": "failed",
+			"last_sync_at":     time.Now().Unix(),
+			"error":            err.Error(),
+		})
+		return false, false
+	}
+
 	var data struct {
 		Success bool            `json:"success"`
 		ETag    string          `json:"eTag"`
@@ -113,11 +242,12 @@ func syncOneLink(link *models.Link) (changed bool) {
 	}
 
 	if err := json.Unmarshal(body, &data); err != nil {
-		log.Println("[Sync] Failed to unmarshal response:", err)
-		return false
+		slog.Warn("Failed to decode link sync response", "link", link.ID, "err", err)
+		return false, false
 	}
 
 	if resp.StatusCode != 200 {
+		notifyLinkFailure(link, fmt.Sprintf("status %d: %s", resp.StatusCode, data.Error))
 		database.DB.Model(link).Updates(map[string]interface{}{
 			"last_sync_status": "failed",
 			"last_sync_at":     time.Now().Unix(),
@@ -126,7 +256,7 @@ func syncOneLink(link *models.Link) (changed bool) {
 			"users":  models.JSON(nil),
 			"server": models.JSON(nil),
 		})
-		return false
+		return false, false
 	}
 
 	// ETag unchanged, no update needed
@@ -138,7 +268,7 @@ func syncOneLink(link *models.Link) (changed bool) {
 				"error":            nil,
 			})
 		}
-		return false
+		return false, true
 	}
 
 	serverBytes, _ := data.Server.MarshalJSON()
@@ -168,5 +298,18 @@ func syncOneLink(link *models.Link) (changed bool) {
 	}
 	database.DB.Model(link).Updates(updates)
 
-	return true
+	return true, true
+}
+
+// notifyLinkFailure alerts when a link goes from healthy to failed, so a
+// peer that stays down does not trigger an alert on every retry.
+func notifyLinkFailure(link *models.Link, reason string) {
+	if link.LastSyncStatus == "failed" {
+		return
+	}
+	name := link.Link
+	if link.Name != nil && *link.Name != "" {
+		name = *link.Name
+	}
+	Notify(EventLinkSyncFailed, "Sync with %s failed: %s", name, reason)
 }
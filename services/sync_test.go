@@ -0,0 +1,90 @@
+package services
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"freegfw/models"
+)
+
+func TestLinkSchedulerBackoff(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	backoffs := []time.Duration{
+		15 * time.Second,
+		30 * time.Second,
+		time.Minute,
+		2 * time.Minute,
+		4 * time.Minute,
+		8 * time.Minute,
+		16 * time.Minute,
+		30 * time.Minute,
+		30 * time.Minute,
+	}
+	s := &linkScheduler{next: make(map[uint]time.Time), failures: make(map[uint]int)}
+	link := &models.Link{ID: 1}
+
+	// Twice, to see a success start the sequence over
+	for round := 0; round < 2; round++ {
+		now := start
+		for i, backoff := range backoffs {
+			s.done(link.ID, false, now)
+			wait := s.next[link.ID].Sub(now)
+			if lo, hi := backoff*4/5, backoff*6/5; wait < lo || wait > hi {
+				t.Errorf("round %d, failure %d: retry after %v, want %v to %v", round, i+1, wait, lo, hi)
+			}
+			if s.due(link, now.Add(wait-time.Second)) || !s.due(link, now.Add(wait)) {
+				t.Errorf("round %d, failure %d: not due exactly at the retry", round, i+1)
+			}
+			now = now.Add(wait)
+		}
+
+		s.done(link.ID, true, now)
+		if wait := s.next[link.ID].Sub(now); wait != linkPollInterval {
+			t.Errorf("round %d: next sync after a success in %v, want %v", round, wait, linkPollInterval)
+		}
+		if _, ok := s.failures[link.ID]; ok {
+			t.Errorf("round %d: failures kept after a success", round)
+		}
+	}
+}
+
+func TestLinkSchedulerJitter(t *testing.T) {
+	// Failures at the same time must not all retry at the same time
+	s := &linkScheduler{next: make(map[uint]time.Time), failures: make(map[uint]int)}
+	now := time.Unix(1700000000, 0)
+	retries := map[time.Time]bool{}
+	for id := uint(1); id <= 20; id++ {
+		s.done(id, false, now)
+		retries[s.next[id]] = true
+	}
+	if len(retries) < 2 {
+		t.Error("all links retry at the same time")
+	}
+}
+
+func TestDebouncer(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	var calls atomic.Int32
+	d := &debouncer{delay: delay, fn: func() { calls.Add(1) }}
+
+	// A burst shorter than the delay between triggers is coalesced
+	for i := 0; i < 5; i++ {
+		d.trigger()
+		time.Sleep(delay / 5)
+	}
+	if n := calls.Load(); n != 0 {
+		t.Fatalf("ran %d times during the burst", n)
+	}
+	time.Sleep(3 * delay)
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("ran %d times after the burst, want once", n)
+	}
+
+	// And the next one runs again
+	d.trigger()
+	time.Sleep(3 * delay)
+	if n := calls.Load(); n != 2 {
+		t.Errorf("ran %d times after the second trigger, want twice", n)
+	}
+}
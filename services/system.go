@@ -0,0 +1,259 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"freegfw/database"
+	"freegfw/models"
+)
+
+// systemSampleInterval is how often host metrics are read and broadcast.
+const systemSampleInterval = 2 * time.Second
+
+type CPUStats struct {
+	Cores int `json:"cores"`
+	// Host and Process are percentages of the whole machine.
+	Host    float64 `json:"host"`
+	Process float64 `json:"process"`
+}
+
+type MemoryStats struct {
+	Total     uint64  `json:"total"`
+	Available uint64  `json:"available"`
+	Process   uint64  `json:"process"`
+	Percent   float64 `json:"percent"` // host memory in use
+}
+
+type FDStats struct {
+	Open  int    `json:"open"`
+	Limit uint64 `json:"limit"`
+}
+
+type NetworkStats struct {
+	RxBytes uint64 `json:"rx_bytes"`
+	TxBytes uint64 `json:"tx_bytes"`
+	// Throughput in bytes per second since the previous sample
+	RxRate uint64 `json:"rx_rate"`
+	TxRate uint64 `json:"tx_rate"`
+}
+
+type DiskStats struct {
+	Path    string  `json:"path"`
+	Total   uint64  `json:"total"`
+	Free    uint64  `json:"free"`
+	Percent float64 `json:"percent"`
+}
+
+// SystemStats is broadcast as the "system" SSE event.
+type SystemStats struct {
+	Time        time.Time    `json:"time"`
+	CPU         CPUStats     `json:"cpu"`
+	Memory      MemoryStats  `json:"memory"`
+	Goroutines  int          `json:"goroutines"`
+	FDs         FDStats      `json:"fds"`
+	Load        [3]float64   `json:"load"`
+	Network     NetworkStats `json:"network"`
+	Disk        DiskStats    `json:"disk"`
+	Connections int          `json:"connections"`
+}
+
+// WatchdogConfig is stored as JSON in the "watchdog" setting. A limit of 0
+// disables that check.
+type WatchdogConfig struct {
+	Enabled          bool    `json:"enabled"`
+	MaxConnections   int     `json:"max_connections"`
+	MaxGoroutines    int     `json:"max_goroutines"`
+	MaxMemoryPercent float64 `json:"max_memory_percent"` // process RSS as a share of host memory
+	MaxFDPercent     float64 `json:"max_fd_percent"`     // open descriptors as a share of the limit
+	// Samples is how many consecutive samples must exceed a limit before
+	// the engine is restarted.
+	Samples int `json:"samples"`
+	// Cooldown is the minimum number of seconds between two restarts.
+	Cooldown int `json:"cooldown"`
+}
+
+type systemMonitor struct {
+	mu   sync.RWMutex
+	last SystemStats
+
+	prevBusy, prevTotal, prevProcess uint64
+	prevRx, prevTx                   uint64
+	prevTime                         time.Time
+
+	watchdog    atomic.Pointer[WatchdogConfig]
+	exceeded    int
+	lastRestart time.Time
+}
+
+var system = &systemMonitor{}
+
+// CurrentSystemStats returns the latest sample.
+func CurrentSystemStats() SystemStats {
+	system.mu.RLock()
+	defer system.mu.RUnlock()
+	return system.last
+}
+
+func LoadWatchdogConfig() WatchdogConfig {
+	cfg := WatchdogConfig{
+		Enabled:        true,
+		MaxConnections: 8000,
+		MaxFDPercent:   90,
+		Samples:        3,
+		Cooldown:       300,
+	}
+	var s models.Setting
+	database.DB.Where("key = ?", "watchdog").Limit(1).Find(&s)
+	if len(s.Value) > 0 {
+		if err := json.Unmarshal(s.Value, &cfg); err != nil {
+			slog.Warn("Invalid watchdog setting", "err", err)
+		}
+	}
+	// The stored setting may have been written without SaveWatchdogConfig
+	if cfg.Samples < 1 {
+		cfg.Samples = 1
+	}
+	return cfg
+}
+
+func SaveWatchdogConfig(cfg WatchdogConfig) error {
+	if cfg.MaxConnections < 0 || cfg.MaxGoroutines < 0 || cfg.MaxMemoryPercent < 0 || cfg.MaxFDPercent < 0 || cfg.Cooldown < 0 {
+		return errors.New("limits must not be negative")
+	}
+	if cfg.MaxMemoryPercent > 100 || cfg.MaxFDPercent > 100 {
+		return errors.New("percentages must not exceed 100")
+	}
+	if cfg.Samples < 1 {
+		cfg.Samples = 1
+	}
+	val, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	saveSetting("watchdog", val)
+	system.watchdog.Store(&cfg)
+	return nil
+}
+
+func (m *systemMonitor) run() {
+	cfg := LoadWatchdogConfig()
+	m.watchdog.Store(&cfg)
+
+	ticker := time.NewTicker(systemSampleInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		stats := m.sample(now)
+		if Hub != nil {
+			Hub.Broadcast("system", stats)
+		}
+		m.checkWatchdog(stats)
+	}
+}
+
+func (m *systemMonitor) sample(now time.Time) SystemStats {
+	stats := SystemStats{
+		Time:        now,
+		Goroutines:  runtime.NumGoroutine(),
+		Load:        readLoadAvg(),
+		Connections: activeConnections(),
+	}
+
+	busy, total, process := readCPUTicks()
+	stats.CPU.Cores = runtime.NumCPU()
+	if dTotal := total - m.prevTotal; m.prevTotal > 0 && dTotal > 0 {
+		stats.CPU.Host = float64(busy-m.prevBusy) * 100 / float64(dTotal)
+		stats.CPU.Process = float64(process-m.prevProcess) * 100 / float64(dTotal)
+	}
+	m.prevBusy, m.prevTotal, m.prevProcess = busy, total, process
+
+	stats.Memory.Total, stats.Memory.Available, stats.Memory.Process = readMemory()
+	if stats.Memory.Total > 0 {
+		stats.Memory.Percent = float64(stats.Memory.Total-stats.Memory.Available) * 100 / float64(stats.Memory.Total)
+	}
+
+	stats.FDs.Open, stats.FDs.Limit = readFDs()
+
+	rx, tx := readNetBytes()
+	stats.Network.RxBytes, stats.Network.TxBytes = rx, tx
+	if elapsed := now.Sub(m.prevTime).Seconds(); !m.prevTime.IsZero() && elapsed > 0 && rx >= m.prevRx && tx >= m.prevTx {
+		stats.Network.RxRate = uint64(float64(rx-m.prevRx) / elapsed)
+		stats.Network.TxRate = uint64(float64(tx-m.prevTx) / elapsed)
+	}
+	m.prevRx, m.prevTx, m.prevTime = rx, tx, now
+
+	stats.Disk.Path = "data"
+	stats.Disk.Total, stats.Disk.Free = readDiskUsage("data")
+	if stats.Disk.Total > 0 {
+		stats.Disk.Percent = float64(stats.Disk.Total-stats.Disk.Free) * 100 / float64(stats.Disk.Total)
+	}
+
+	m.mu.Lock()
+	m.last = stats
+	m.mu.Unlock()
+	return stats
+}
+
+// activeConnections returns the number of connections of the running engine.
+func activeConnections() int {
+	if coreInstance == nil {
+		return 0
+	}
+	if coreInstance.CurrentEngine == "xray" {
+		return xrayConnections.count()
+	}
+	if tm := coreInstance.TrafficManager; tm != nil {
+		return tm.ConnectionsLen()
+	}
+	return 0
+}
+
+// checkWatchdog restarts the engine when a limit has been exceeded for the
+// configured number of consecutive samples, e.g. on a goroutine or
+// connection leak.
+func (m *systemMonitor) checkWatchdog(stats SystemStats) {
+	cfg := m.watchdog.Load()
+	if cfg == nil || !cfg.Enabled || coreInstance == nil || !coreInstance.IsRunning() {
+		m.exceeded = 0
+		return
+	}
+
+	var reasons []string
+	if cfg.MaxConnections > 0 && stats.Connections > cfg.MaxConnections {
+		reasons = append(reasons, fmt.Sprintf("connections %d > %d", stats.Connections, cfg.MaxConnections))
+	}
+	if cfg.MaxGoroutines > 0 && stats.Goroutines > cfg.MaxGoroutines {
+		reasons = append(reasons, fmt.Sprintf("goroutines %d > %d", stats.Goroutines, cfg.MaxGoroutines))
+	}
+	if cfg.MaxMemoryPercent > 0 && stats.Memory.Total > 0 {
+		if p := float64(stats.Memory.Process) * 100 / float64(stats.Memory.Total); p > cfg.MaxMemoryPercent {
+			reasons = append(reasons, fmt.Sprintf("memory %.1f%% > %.1f%%", p, cfg.MaxMemoryPercent))
+		}
+	}
+	if cfg.MaxFDPercent > 0 && stats.FDs.Limit > 0 {
+		if p := float64(stats.FDs.Open) * 100 / float64(stats.FDs.Limit); p > cfg.MaxFDPercent {
+			reasons = append(reasons, fmt.Sprintf("file descriptors %.1f%% > %.1f%%", p, cfg.MaxFDPercent))
+		}
+	}
+
+	if len(reasons) == 0 {
+		m.exceeded = 0
+		return
+	}
+	m.exceeded++
+	if m.exceeded < cfg.Samples || time.Since(m.lastRestart) < time.Duration(cfg.Cooldown)*time.Second {
+		return
+	}
+
+	m.exceeded = 0
+	m.lastRestart = time.Now()
+	Notify(EventWatchdogRestart, "Watchdog limit exceeded (%s), possible leak. Restarting engine...", strings.Join(reasons, ", "))
+	go coreInstance.Restart()
+}
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// readCPUTicks returns the busy and total jiffies of all CPUs from /proc/stat
+// and the user+system jiffies of this process from /proc/self/stat.
+func readCPUTicks() (busy, total, process uint64) {
+	if data, err := os.ReadFile("/proc/stat"); err == nil {
+		line, _, _ := strings.Cut(string(data), "\n")
+		fields := strings.Fields(line)
+		if len(fields) > 1 && fields[0] == "cpu" {
+			for i, f := range fields[1:] {
+				v, _ := strconv.ParseUint(f, 10, 64)
+				total += v
+				// idle and iowait
+				if i != 3 && i != 4 {
+					busy += v
+				}
+			}
+		}
+	}
+	if data, err := os.ReadFile("/proc/self/stat"); err == nil {
+		// The command name may contain spaces; fields start after the closing paren
+		if i := strings.LastIndexByte(string(data), ')'); i >= 0 {
+			fields := strings.Fields(string(data[i+1:]))
+			// utime and stime are fields 14 and 15, i.e. 11 and 12 after the paren
+			if len(fields) > 12 {
+				utime, _ := strconv.ParseUint(fields[11], 10, 64)
+				stime, _ := strconv.ParseUint(fields[12], 10, 64)
+				process = utime + stime
+			}
+		}
+	}
+	return
+}
+
+// readMemory returns host total and available memory and this process's
+// resident set size, in bytes.
+func readMemory() (total, available, rss uint64) {
+	total = readKBField("/proc/meminfo", "MemTotal:")
+	available = readKBField("/proc/meminfo", "MemAvailable:")
+	rss = readKBField("/proc/self/status", "VmRSS:")
+	return
+}
+
+func readKBField(path, key string) uint64 {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, key) {
+			fields := strings.Fields(line[len(key):])
+			if len(fields) > 0 {
+				v, _ := strconv.ParseUint(fields[0], 10, 64)
+				return v * 1024
+			}
+		}
+	}
+	return 0
+}
+
+// readFDs returns the number of open descriptors and the soft limit.
+func readFDs() (open int, limit uint64) {
+	if entries, err := os.ReadDir("/proc/self/fd"); err == nil {
+		open = len(entries)
+	}
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err == nil {
+		limit = rl.Cur
+	}
+	return
+}
+
+func readLoadAvg() (load [3]float64) {
+	data, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return
+	}
+	fields := strings.Fields(string(data))
+	for i := 0; i < 3 && i < len(fields); i++ {
+		load[i], _ = strconv.ParseFloat(fields[i], 64)
+	}
+	return
+}
+
+// readNetBytes sums received and transmitted bytes of all interfaces except loopback.
+func readNetBytes() (rx, tx uint64) {
+	f, err := os.Open("/proc/net/dev")
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		name, rest, ok := strings.Cut(scanner.Text(), ":")
+		if !ok || strings.TrimSpace(name) == "lo" {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) < 9 {
+			continue
+		}
+		r, _ := strconv.ParseUint(fields[0], 10, 64)
+		t, _ := strconv.ParseUint(fields[8], 10, 64)
+		rx += r
+		tx += t
+	}
+	return
+}
+
+func readDiskUsage(path string) (total, free uint64) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return
+	}
+	return st.Blocks * uint64(st.Bsize), st.Bavail * uint64(st.Bsize)
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package services
+
+// Host metrics are read from /proc and are only available on Linux.
+
+func readCPUTicks() (busy, total, process uint64) { return }
+
+func readMemory() (total, available, rss uint64) { return }
+
+func readFDs() (open int, limit uint64) { return }
+
+func readLoadAvg() (load [3]float64) { return }
+
+func readNetBytes() (rx, tx uint64) { return }
+
+func readDiskUsage(path string) (total, free uint64) { return }
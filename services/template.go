@@ -7,7 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
@@ -108,7 +108,7 @@ var templateFS embed.FS
 func MigrateTemplates() {
 	files, err := templateFS.ReadDir("templates")
 	if err != nil {
-		log.Println("Error reading templates directory:", err)
+		slog.Error("Failed to read templates directory", "err", err)
 		return
 	}
 	for _, f := range files {
@@ -125,7 +125,7 @@ func MigrateTemplates() {
 						Name:    t.Name,
 						Content: models.JSON(content),
 					})
-					log.Println("Migrated template:", slug)
+					slog.Info("Migrated template", "slug", slug)
 				}
 			}
 		}
@@ -145,7 +145,7 @@ func GetTemplates() ([]TemplateInfo, error) {
 	for _, t := range templates {
 		var tc TemplateConfig
 		if err := json.Unmarshal(t.Content, &tc); err != nil {
-			log.Println("Error unmarshal template:", err)
+			slog.Error("Failed to decode template", "err", err)
 			continue
 		}
 
@@ -260,9 +260,11 @@ func InitTemplate(name string) error {
 			UUID:     utils.RandomUUID(),
 		}
 		database.DB.Create(&defaultUser)
-		log.Println("Created default user during initialization")
+		InvalidateUserIndex()
+		slog.Info("Created default user during initialization")
 	}
 
+	NotifyLinkPeers()
 	return nil
 }
 
@@ -328,6 +330,9 @@ func BuildUsers(templateName string) ([]map[string]interface{}, error) {
 
 	// 1. 处理本地自有用户
 	for _, u := range users {
+		if !u.CanUseNode(models.LocalNodeID) {
+			continue
+		}
 		res = append(res, buildUserMap(u.Username, u.UUID, u.UUID, u.SpeedLimit))
 	}
 
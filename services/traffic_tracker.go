@@ -4,8 +4,11 @@ import (
 	"context"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"freegfw/models"
+
 	"github.com/sagernet/sing-box/adapter"
 	"github.com/sagernet/sing-box/experimental/clashapi/trafficontrol"
 	"github.com/sagernet/sing/common/buf"
@@ -54,6 +57,7 @@ func (t *StatisticsTracker) UpdateLimits(limits map[string]uint64) {
 }
 
 func (t *StatisticsTracker) RoutedConnection(ctx context.Context, conn net.Conn, metadata adapter.InboundContext, matchedRule adapter.Rule, matchOutbound adapter.Outbound) net.Conn {
+	conn = NewCountingConn(conn, newConnAccounting(metadata.User, singboxHost(metadata), singboxAccessLog(metadata)))
 	limiter := t.getLimiter(metadata)
 	if limiter != nil {
 		conn = NewRateLimitedConn(conn, limiter)
@@ -62,6 +66,7 @@ func (t *StatisticsTracker) RoutedConnection(ctx context.Context, conn net.Conn,
 }
 
 func (t *StatisticsTracker) RoutedPacketConnection(ctx context.Context, conn N.PacketConn, metadata adapter.InboundContext, matchedRule adapter.Rule, matchOutbound adapter.Outbound) N.PacketConn {
+	conn = NewCountingPacketConn(conn, newConnAccounting(metadata.User, singboxHost(metadata), singboxAccessLog(metadata)))
 	limiter := t.getLimiter(metadata)
 	if limiter != nil {
 		conn = NewRateLimitedPacketConn(conn, limiter)
@@ -217,3 +222,199 @@ func (c *RateLimitedPacketConn) SetReadDeadline(t time.Time) error {
 func (c *RateLimitedPacketConn) SetWriteDeadline(t time.Time) error {
 	return c.conn.SetWriteDeadline(t)
 }
+
+// connAccounting fans the bytes of one connection out to everything that
+// keeps statistics: the per-user accounting pipeline, the per-destination
+// aggregates and, when enabled, the access log. Both engines create one per
+// connection.
+type connAccounting struct {
+	user      *trafficCounter
+	dest      *destinationEntry
+	closeOnce sync.Once
+
+	// Only used when access is set
+	access   *models.AccessLog
+	up, down atomic.Int64
+	failure  atomic.Pointer[string]
+}
+
+func newConnAccounting(user, host string, access *models.AccessLog) *connAccounting {
+	return &connAccounting{
+		user:   accounting.counterFor(user),
+		dest:   destinations.open(host, user),
+		access: access,
+	}
+}
+
+func (a *connAccounting) add(up, down int64) {
+	accounting.add(a.user, up, down)
+	if a.dest != nil {
+		a.dest.up.Add(up)
+		a.dest.down.Add(down)
+	}
+	if a.access != nil {
+		a.up.Add(up)
+		a.down.Add(down)
+	}
+}
+
+// fail remembers the first error that was not a normal close.
+func (a *connAccounting) fail(err error) {
+	if a.access == nil || isNormalClose(err) {
+		return
+	}
+	msg := err.Error()
+	a.failure.CompareAndSwap(nil, &msg)
+}
+
+// close is safe to call more than once.
+func (a *connAccounting) close() {
+	a.closeOnce.Do(func() {
+		if a.dest != nil {
+			a.dest.close()
+		}
+		if a.access != nil {
+			a.access.Upload = a.up.Load()
+			a.access.Download = a.down.Load()
+			a.access.Duration = time.Since(a.access.StartedAt).Milliseconds()
+			switch {
+			case a.failure.Load() != nil:
+				a.access.Outcome = "error: " + *a.failure.Load()
+			case a.access.Download == 0:
+				a.access.Outcome = "no_response"
+			default:
+				a.access.Outcome = "ok"
+			}
+			accessLog.push(a.access)
+		}
+	})
+}
+
+// singboxAccessLog starts an access log row for a sing-box connection.
+func singboxAccessLog(metadata adapter.InboundContext) *models.AccessLog {
+	return accessLog.begin("sing-box", metadata.User, metadata.InboundType, metadata.Network,
+		metadata.Source.String(), metadata.Destination.String())
+}
+
+// singboxHost returns the domain (or SNI) of the destination, falling back to its address.
+func singboxHost(metadata adapter.InboundContext) string {
+	if metadata.Domain != "" {
+		return metadata.Domain
+	}
+	if metadata.Destination.Fqdn != "" {
+		return metadata.Destination.Fqdn
+	}
+	if metadata.Destination.Addr.IsValid() {
+		return metadata.Destination.Addr.String()
+	}
+	return ""
+}
+
+// CountingConn feeds the bytes of an inbound connection into connAccounting.
+// Reads from the client are uploads, writes are downloads. Like
+// RateLimitedConn it does not embed net.Conn so that io.ReaderFrom and
+// friends cannot bypass the counters.
+type CountingConn struct {
+	conn net.Conn
+	acct *connAccounting
+}
+
+func NewCountingConn(conn net.Conn, acct *connAccounting) net.Conn {
+	return &CountingConn{conn: conn, acct: acct}
+}
+
+func (c *CountingConn) Read(b []byte) (n int, err error) {
+	n, err = c.conn.Read(b)
+	c.acct.add(int64(n), 0)
+	if err != nil {
+		c.acct.fail(err)
+	}
+	return
+}
+
+func (c *CountingConn) Write(b []byte) (n int, err error) {
+	n, err = c.conn.Write(b)
+	c.acct.add(0, int64(n))
+	if err != nil {
+		c.acct.fail(err)
+	}
+	return
+}
+
+func (c *CountingConn) Close() error {
+	c.acct.close()
+	return c.conn.Close()
+}
+
+func (c *CountingConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *CountingConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c *CountingConn) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(t)
+}
+
+func (c *CountingConn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *CountingConn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+// CountingPacketConn is the N.PacketConn counterpart of CountingConn.
+type CountingPacketConn struct {
+	conn N.PacketConn
+	acct *connAccounting
+}
+
+func NewCountingPacketConn(conn N.PacketConn, acct *connAccounting) *CountingPacketConn {
+	return &CountingPacketConn{conn: conn, acct: acct}
+}
+
+func (c *CountingPacketConn) ReadPacket(buffer *buf.Buffer) (destination M.Socksaddr, err error) {
+	destination, err = c.conn.ReadPacket(buffer)
+	if err == nil {
+		c.acct.add(int64(buffer.Len()), 0)
+	} else {
+		c.acct.fail(err)
+	}
+	return
+}
+
+func (c *CountingPacketConn) WritePacket(buffer *buf.Buffer, destination M.Socksaddr) error {
+	// WritePacket releases the buffer, so measure it first
+	n := int64(buffer.Len())
+	err := c.conn.WritePacket(buffer, destination)
+	if err == nil {
+		c.acct.add(0, n)
+	} else {
+		c.acct.fail(err)
+	}
+	return err
+}
+
+func (c *CountingPacketConn) Close() error {
+	c.acct.close()
+	return c.conn.Close()
+}
+
+func (c *CountingPacketConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *CountingPacketConn) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(t)
+}
+
+func (c *CountingPacketConn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *CountingPacketConn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
@@ -8,7 +8,7 @@ import (
 	"freegfw/database"
 	"freegfw/models"
 
-	"log"
+	"log/slog"
 
 	xray_core "github.com/xtls/xray-core/core"
 )
@@ -54,7 +54,7 @@ func (c *CoreService) refreshXray(server map[string]interface{}, templateName st
 			}
 		}
 	}
-	log.Printf("[XrayConfig] Configured %d users for Xray", len(xrayUsers))
+	slog.Debug("Configured xray users", "count", len(xrayUsers))
 
 	// Update tracker limits if exists (might be nil now, initialized in Start)
 	if c.tracker != nil {
@@ -220,10 +220,10 @@ func (c *CoreService) refreshXray(server map[string]interface{}, templateName st
 
 		if warpAccount == nil || warpAccount.PrivateKey == "" {
 			// Auto register
-			log.Println("Auto-registering Cloudflare WARP account for Xray...")
+			slog.Info("Auto-registering Cloudflare WARP account", "engine", "xray")
 			acc, err := RegisterWarp()
 			if err != nil {
-				log.Println("Failed to register warp:", err)
+				slog.Error("Failed to register warp", "err", err)
 				outbounds = append(outbounds, map[string]interface{}{"protocol": "freedom"})
 			} else {
 				warpAccount = acc
@@ -262,10 +262,16 @@ func (c *CoreService) refreshXray(server map[string]interface{}, templateName st
 		outbounds = append(outbounds, map[string]interface{}{"protocol": "freedom"})
 	}
 
+	// Xray's access log prints one line per connection; only keep it when debugging
+	xrayLog := map[string]interface{}{
+		"loglevel": LoadLogConfig().XrayLevel,
+	}
+	if xrayLog["loglevel"] != "debug" {
+		xrayLog["access"] = "none"
+	}
+
 	config := map[string]interface{}{
-		"log": map[string]interface{}{
-			"loglevel": "info",
-		},
+		"log":       xrayLog,
 		"stats":     stats,
 		"policy":    policy,
 		"inbounds":  []interface{}{inbound},
@@ -281,11 +287,9 @@ func monitorXrayLoop(instance *xray_core.Instance) {
 	ticker := time.NewTicker(1 * time.Second)
 	defer ticker.Stop()
 
-	// Store last cumulative values to calculate diff
-	// user -> {Up, Down}
-	lastStats := make(map[string]struct{ Up, Down int64 })
-	userTraffic := make(map[string]struct{ Up, Down int64 })
-	var flushCounter int
+	// Per-user bytes are counted by the XrayDispatcher wrappers and flushed
+	// by the accounting pipeline; this loop only reports.
+	lastUp, lastDown := accounting.totals()
 	currentEngine := coreInstance.CurrentEngine
 
 	for range ticker.C {
@@ -293,107 +297,36 @@ func monitorXrayLoop(instance *xray_core.Instance) {
 			return
 		}
 
-		totalUp := int64(0)
-		totalDown := int64(0)
-		diffUpTotal := int64(0)
-		diffDownTotal := int64(0)
-
-		// Create a set of all users to check (from database and UserLimits)
-		usersToCheck := make(map[string]bool)
-
-		var allUsers []models.User
-		if err := database.DB.Find(&allUsers).Error; err == nil {
-			for _, u := range allUsers {
-				usersToCheck[u.Username] = true
-				if u.UUID != "" {
-					usersToCheck[u.UUID] = true
-				}
-			}
-		}
-
-		if coreInstance != nil && coreInstance.UserLimits != nil {
-			for u := range coreInstance.UserLimits {
-				usersToCheck[u] = true
-			}
-		}
-
-		for user := range usersToCheck {
-			cUp := int64(0)
-			cDown := int64(0)
-
-			// 2. Get from Internal Stats Manager
-			if coreInstance != nil && coreInstance.XrayStats != nil {
-				// Pattern: user>>>[email]>>>traffic>>>uplink
-				if upCounter := coreInstance.XrayStats.GetCounter("user>>>" + user + ">>>traffic>>>uplink"); upCounter != nil {
-					cUp += upCounter.Value()
-				}
-				if downCounter := coreInstance.XrayStats.GetCounter("user>>>" + user + ">>>traffic>>>downlink"); downCounter != nil {
-					cDown += downCounter.Value()
-				}
-			}
-
-			if cUp > 0 || cDown > 0 {
-				totalUp += cUp
-				totalDown += cDown
-
-				prev, ok := lastStats[user]
-				if !ok {
-					lastStats[user] = struct{ Up, Down int64 }{cUp, cDown}
-					continue
-				}
-
-				dUp := cUp - prev.Up
-				dDown := cDown - prev.Down
-				if dUp < 0 {
-					dUp = 0
-				}
-				if dDown < 0 {
-					dDown = 0
-				}
+		currUp, currDown := accounting.totals()
+		diffUp := currUp - lastUp
+		diffDown := currDown - lastDown
+		lastUp = currUp
+		lastDown = currDown
 
-				diffUpTotal += dUp
-				diffDownTotal += dDown
-
-				lastStats[user] = struct{ Up, Down int64 }{cUp, cDown}
-
-				uT := userTraffic[user]
-				uT.Up += dUp
-				uT.Down += dDown
-				userTraffic[user] = uT
-			}
-		}
+		metrics.setSpeed(diffUp, diffDown)
 
 		if Hub != nil {
 			speed := map[string]float64{
-				"up":   float64(diffUpTotal) * 8 / 1000000,
-				"down": float64(diffDownTotal) * 8 / 1000000,
+				"up":   float64(diffUp) * 8 / 1000000,
+				"down": float64(diffDown) * 8 / 1000000,
 			}
 			Hub.Broadcast("speed", speed)
 
 			total := map[string]int64{
-				"up":   totalUp,
-				"down": totalDown,
+				"up":   currUp,
+				"down": currDown,
 			}
 			Hub.Broadcast("traffic", total)
 
-			Hub.Broadcast("connections", map[string]interface{}{"connections": []interface{}{}})
+			Hub.Broadcast("connections", xrayConnections.snapshot())
 		}
 
-		flushCounter++
-		if flushCounter >= 10 {
-			for name, traffic := range userTraffic {
-				if traffic.Up > 0 || traffic.Down > 0 {
-					var user models.User
-					if err := database.DB.Where("uuid = ?", name).Or("username = ?", name).First(&user).Error; err == nil {
-						database.DB.Model(&user).Updates(map[string]interface{}{
-							"upload":   user.Upload + traffic.Up,
-							"download": user.Download + traffic.Down,
-						})
-					}
-				}
+		userConns := make(map[string]int)
+		for _, conn := range xrayConnections.list() {
+			if conn.User != "" {
+				userConns[conn.User]++
 			}
-			userTraffic = make(map[string]struct{ Up, Down int64 })
-			flushCounter = 0
 		}
+		metrics.setUserConnections(userConns)
 	}
 }
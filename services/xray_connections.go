@@ -0,0 +1,164 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/session"
+)
+
+// xrayConnection is the Xray counterpart of sing-box's
+// trafficontrol.TrackerMetadata for a connection seen by XrayDispatcher.
+type xrayConnection struct {
+	ID          string
+	User        string
+	Inbound     string
+	Source      net.Destination
+	Destination net.Destination
+	Start       time.Time
+	Upload      atomic.Int64
+	Download    atomic.Int64
+
+	// outbound is where Xray's dispatcher records the outbound it routes the
+	// connection to; chain is the tag read from it once routing is done.
+	outbound *session.Outbound
+	chain    atomic.Pointer[string]
+}
+
+// withXrayOutbound makes sure ctx carries the session outbound that Xray's
+// dispatcher records its routing decision in, as the dispatcher itself
+// would, so the tracker sees the same one.
+func withXrayOutbound(ctx context.Context) context.Context {
+	if len(session.OutboundsFromContext(ctx)) == 0 {
+		ctx = session.ContextWithOutbounds(ctx, []*session.Outbound{{}})
+	}
+	return ctx
+}
+
+// routed records the outbound the connection was routed to. Xray sets it
+// before the outbound handler runs, so it must only be called once data
+// came back from the outbound.
+func (c *xrayConnection) routed() {
+	if c.outbound != nil && c.chain.Load() == nil {
+		tag := c.outbound.Tag
+		c.chain.Store(&tag)
+	}
+}
+
+// MarshalJSON produces the same shape as trafficontrol.TrackerMetadata so the
+// dashboard can render both engines identically. Xray does not expose the
+// routing rule that matched, so rule and rulePayload are left out, and
+// chains stays empty until the outbound has answered.
+func (c *xrayConnection) MarshalJSON() ([]byte, error) {
+	host := ""
+	destinationIP := ""
+	if c.Destination.Address != nil {
+		if c.Destination.Address.Family().IsDomain() {
+			host = c.Destination.Address.Domain()
+		} else {
+			destinationIP = c.Destination.Address.IP().String()
+		}
+	}
+	sourceIP := ""
+	if c.Source.Address != nil {
+		sourceIP = c.Source.Address.String()
+	}
+	chains := []string{}
+	if tag := c.chain.Load(); tag != nil && *tag != "" {
+		chains = []string{*tag}
+	}
+	return json.Marshal(map[string]interface{}{
+		"id": c.ID,
+		"metadata": map[string]interface{}{
+			"network":         c.Destination.Network.SystemString(),
+			"type":            c.Inbound,
+			"sourceIP":        sourceIP,
+			"destinationIP":   destinationIP,
+			"sourcePort":      c.Source.Port.String(),
+			"destinationPort": c.Destination.Port.String(),
+			"host":            host,
+			"dnsMode":         "normal",
+			"processPath":     "",
+			"user":            c.User,
+		},
+		"upload":   c.Upload.Load(),
+		"download": c.Download.Load(),
+		"start":    c.Start,
+		"chains":   chains,
+	})
+}
+
+type xrayConnectionTable struct {
+	mu    sync.RWMutex
+	conns map[string]*xrayConnection
+}
+
+var xrayConnections = &xrayConnectionTable{
+	conns: make(map[string]*xrayConnection),
+}
+
+// track registers a dispatched connection until untrack is called for it.
+func (t *xrayConnectionTable) track(ctx context.Context, dest net.Destination, user string) *xrayConnection {
+	conn := &xrayConnection{
+		ID:          uuid.New().String(),
+		User:        user,
+		Destination: dest,
+		Start:       time.Now(),
+	}
+	if inbound := session.InboundFromContext(ctx); inbound != nil {
+		conn.Source = inbound.Source
+		conn.Inbound = inbound.Name
+		if inbound.Tag != "" {
+			conn.Inbound += "/" + inbound.Tag
+		}
+	}
+	if outbounds := session.OutboundsFromContext(ctx); len(outbounds) > 0 {
+		conn.outbound = outbounds[len(outbounds)-1]
+	}
+
+	t.mu.Lock()
+	t.conns[conn.ID] = conn
+	t.mu.Unlock()
+	return conn
+}
+
+func (t *xrayConnectionTable) untrack(conn *xrayConnection) {
+	t.mu.Lock()
+	delete(t.conns, conn.ID)
+	t.mu.Unlock()
+}
+
+func (t *xrayConnectionTable) list() []*xrayConnection {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	conns := make([]*xrayConnection, 0, len(t.conns))
+	for _, c := range t.conns {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+func (t *xrayConnectionTable) count() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.conns)
+}
+
+// snapshot mirrors trafficontrol.Snapshot's JSON encoding.
+func (t *xrayConnectionTable) snapshot() map[string]interface{} {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	up, down := accounting.totals()
+	return map[string]interface{}{
+		"downloadTotal": down,
+		"uploadTotal":   up,
+		"connections":   t.list(),
+		"memory":        memStats.StackInuse + memStats.HeapInuse + memStats.HeapIdle - memStats.HeapReleased,
+	}
+}
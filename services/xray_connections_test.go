@@ -0,0 +1,43 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/session"
+)
+
+func TestXrayConnectionChains(t *testing.T) {
+	table := &xrayConnectionTable{conns: make(map[string]*xrayConnection)}
+	ctx, cancel := context.WithCancel(withXrayOutbound(context.Background()))
+	defer cancel()
+	conn := table.track(ctx, net.TCPDestination(net.DomainAddress("example.com"), 443), "alice")
+
+	marshal := func() map[string]interface{} {
+		b, err := json.Marshal(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var m map[string]interface{}
+		json.Unmarshal(b, &m)
+		return m
+	}
+
+	m := marshal()
+	if chains := m["chains"].([]interface{}); len(chains) != 0 {
+		t.Errorf("chains before routing = %v, want none", chains)
+	}
+	if _, ok := m["rule"]; ok {
+		t.Error("rule is reported although Xray does not expose it")
+	}
+
+	// What Xray's dispatcher does once it picked the outbound
+	outbounds := session.OutboundsFromContext(ctx)
+	outbounds[len(outbounds)-1].Tag = "warp"
+	conn.routed()
+	if chains := marshal()["chains"].([]interface{}); len(chains) != 1 || chains[0] != "warp" {
+		t.Errorf("chains = %v, want [warp]", chains)
+	}
+}
@@ -2,8 +2,14 @@ package services
 
 import (
 	"context"
-	"log"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
 
+	"freegfw/models"
+
+	"github.com/xtls/xray-core/common"
 	"github.com/xtls/xray-core/common/buf"
 	"github.com/xtls/xray-core/common/net"
 	"github.com/xtls/xray-core/common/session"
@@ -25,7 +31,8 @@ func NewXrayDispatcher(d routing.Dispatcher, tracker *StatisticsTracker) *XrayDi
 }
 
 func (d *XrayDispatcher) Dispatch(ctx context.Context, dest net.Destination) (*transport.Link, error) {
-	log.Printf("[XrayDispatcher] Dispatch called for dest: %s", dest.String())
+	slog.Debug("Xray dispatch", "dest", dest.String())
+	ctx = withXrayOutbound(ctx)
 	link, err := d.Dispatcher.Dispatch(ctx, dest)
 	if err != nil {
 		return nil, err
@@ -41,19 +48,18 @@ func (d *XrayDispatcher) Dispatch(ctx context.Context, dest net.Destination) (*t
 	inbound := session.InboundFromContext(ctx)
 	if inbound == nil {
 		if content := session.ContentFromContext(ctx); content == nil {
-			log.Printf("[XrayDispatcher] ContentFromContext is nil")
+			slog.Debug("Xray dispatch without content", "dest", dest.String())
 		} else {
 			keys := []string{}
 			for k := range content.Attributes {
 				keys = append(keys, k)
 			}
-			log.Printf("[XrayDispatcher] InboundFromContext is nil. Content Attributes: %v", keys)
+			slog.Debug("Xray dispatch without inbound", "dest", dest.String(), "attributes", keys)
 		}
 	} else if inbound.User == nil {
-		log.Printf("[XrayDispatcher] Inbound.User is nil. Source: %v, Tag: %s", inbound.Source, inbound.Tag)
+		slog.Debug("Xray dispatch without user", "source", inbound.Source.String(), "tag", inbound.Tag)
 	} else {
 		email = inbound.User.Email
-		log.Printf("[XrayDispatcher] Found user: %s", email)
 	}
 
 	// FALLBACK DEBUGGING: Log if missing, but do not default to "unknown" yet
@@ -61,49 +67,73 @@ func (d *XrayDispatcher) Dispatch(ctx context.Context, dest net.Destination) (*t
 		// Check fallback attributes
 		if content := session.ContentFromContext(ctx); content != nil {
 			if uVal, ok := content.Attributes["InboundUser"]; ok {
-				log.Printf("[XrayDispatcher] Found InboundUser in attributes: %T", uVal)
+				slog.Debug("Xray InboundUser found in attributes", "type", fmt.Sprintf("%T", uVal))
 			}
 		}
 	} else {
-		log.Printf("[XrayDispatcher] Found user: %s", email)
-	}
-
-	if email == "" {
-		return link, nil
+		slog.Debug("Xray dispatch user", "user", email)
 	}
 
-	limiter := d.tracker.GetLimiterForUser(email)
+	acct := newConnAccounting(email, xrayHost(dest), xrayAccessLog(ctx, dest, email))
+	tracked := xrayConnections.track(ctx, dest, email)
+	life := newLinkLifetime(ctx, link, func() {
+		xrayConnections.untrack(tracked)
+		acct.close()
+	})
 
-	if limiter != nil {
-		log.Printf("[XrayDispatcher] Rate limiting user: %s, limit: %.2f", email, limiter.Limit())
+	var limiter *rate.Limiter
+	if email != "" {
+		limiter = d.tracker.GetLimiterForUser(email)
+		if limiter != nil {
+			slog.Debug("Xray rate limiting user", "user", email, "limit", float64(limiter.Limit()))
+		}
 	}
 
-	log.Printf("[XrayDispatcher] Wrapping connection for user: %s", email)
-
 	// We need to construct a new Link that wraps the Reader/Writer
 	newLink := &transport.Link{
 		Reader: link.Reader,
 		Writer: link.Writer,
 	}
 
-	// Wrap Reader (Downlink? Upstream to client?)
-	// Link.Reader is what we read from upstream (remote). Writing to client.
-	// Actually, Dispatch returns a Link to write to outbound and read from outbound.
+	// Dispatch returns a Link to write to outbound and read from outbound.
 	// So Link.Writer is writing to outbound (Uplink). Link.Reader is reading from outbound (Downlink).
 
 	if link.Reader != nil {
-		newLink.Reader = &RateLimitedReader{
-			Reader:  link.Reader,
-			limiter: limiter,
-			ctx:     ctx,
+		newLink.Reader = &CountingReader{
+			Reader: link.Reader,
+			record: func(n int64) {
+				tracked.routed()
+				tracked.Download.Add(n)
+				acct.add(0, n)
+			},
+			fail: acct.fail,
+			life: life,
+		}
+		if limiter != nil {
+			newLink.Reader = &RateLimitedReader{
+				Reader:  newLink.Reader,
+				limiter: limiter,
+				ctx:     ctx,
+			}
 		}
 	}
 
 	if link.Writer != nil {
-		newLink.Writer = &RateLimitedWriter{
-			Writer:  link.Writer,
-			limiter: limiter,
-			ctx:     ctx,
+		newLink.Writer = &CountingWriter{
+			Writer: link.Writer,
+			record: func(n int64) {
+				tracked.Upload.Add(n)
+				acct.add(n, 0)
+			},
+			fail: acct.fail,
+			life: life,
+		}
+		if limiter != nil {
+			newLink.Writer = &RateLimitedWriter{
+				Writer:  newLink.Writer,
+				limiter: limiter,
+				ctx:     ctx,
+			}
 		}
 	}
 
@@ -111,26 +141,57 @@ func (d *XrayDispatcher) Dispatch(ctx context.Context, dest net.Destination) (*t
 }
 
 func (d *XrayDispatcher) DispatchLink(ctx context.Context, dest net.Destination, link *transport.Link) error {
-	log.Printf("[XrayDispatcher] DispatchLink called for dest: %s", dest.String())
+	slog.Debug("Xray dispatch link", "dest", dest.String())
+	ctx = withXrayOutbound(ctx)
 
 	// Identify user
 	var email string
 	inbound := session.InboundFromContext(ctx)
 	if inbound != nil && inbound.User != nil {
 		email = inbound.User.Email
-		log.Printf("[XrayDispatcher] DispatchLink Found user: %s", email)
+		slog.Debug("Xray dispatch link user", "user", email)
 	} else if content := session.ContentFromContext(ctx); content != nil {
 		// Fallback check
 		if uVal, ok := content.Attributes["InboundUser"]; ok {
-			log.Printf("[XrayDispatcher] DispatchLink Found InboundUser in attributes: %T", uVal)
+			slog.Debug("Xray InboundUser found in attributes", "type", fmt.Sprintf("%T", uVal))
+		}
+	}
+
+	// Here the link belongs to the inbound: link.Reader carries client data
+	// towards the outbound (Uplink) and link.Writer carries replies (Downlink).
+	acct := newConnAccounting(email, xrayHost(dest), xrayAccessLog(ctx, dest, email))
+	tracked := xrayConnections.track(ctx, dest, email)
+	life := newLinkLifetime(ctx, link, func() {
+		xrayConnections.untrack(tracked)
+		acct.close()
+	})
+	if link.Reader != nil {
+		link.Reader = &CountingReader{
+			Reader: link.Reader,
+			record: func(n int64) {
+				tracked.Upload.Add(n)
+				acct.add(n, 0)
+			},
+			fail: acct.fail,
+			life: life,
+		}
+	}
+	if link.Writer != nil {
+		link.Writer = &CountingWriter{
+			Writer: link.Writer,
+			record: func(n int64) {
+				tracked.routed()
+				tracked.Download.Add(n)
+				acct.add(0, n)
+			},
+			fail: acct.fail,
+			life: life,
 		}
 	}
 
 	if email != "" {
 		limiter := d.tracker.GetLimiterForUser(email)
 
-		log.Printf("[XrayDispatcher] Wrapping connection in DispatchLink for user: %s", email)
-
 		if link.Reader != nil {
 			link.Reader = &RateLimitedReader{
 				Reader:  link.Reader,
@@ -150,18 +211,41 @@ func (d *XrayDispatcher) DispatchLink(ctx context.Context, dest net.Destination,
 	return d.Dispatcher.DispatchLink(ctx, dest, link)
 }
 
+// xrayHost returns the domain of a dispatched destination, falling back to its IP.
+func xrayHost(dest net.Destination) string {
+	if dest.Address == nil {
+		return ""
+	}
+	if dest.Address.Family().IsDomain() {
+		return dest.Address.Domain()
+	}
+	return dest.Address.IP().String()
+}
+
+// xrayAccessLog starts an access log row for an Xray connection.
+func xrayAccessLog(ctx context.Context, dest net.Destination, user string) *models.AccessLog {
+	protocol, source := "", ""
+	if inbound := session.InboundFromContext(ctx); inbound != nil {
+		protocol = inbound.Name
+		if inbound.Source.IsValid() {
+			source = inbound.Source.NetAddr()
+		}
+	}
+	return accessLog.begin("xray", user, protocol, dest.Network.SystemString(), source, dest.NetAddr())
+}
+
 // Xray 1.8+ uses Type(), older used something else.
 func (d *XrayDispatcher) Type() interface{} {
 	return routing.DispatcherType()
 }
 
 func (d *XrayDispatcher) Start() error {
-	log.Printf("[XrayDispatcher] Start called")
+	slog.Debug("Xray dispatcher started")
 	return d.Dispatcher.Start()
 }
 
 func (d *XrayDispatcher) Close() error {
-	log.Printf("[XrayDispatcher] Close called")
+	slog.Debug("Xray dispatcher closed")
 	return d.Dispatcher.Close()
 }
 
@@ -195,6 +279,16 @@ func (w *RateLimitedWriter) WriteMultiBuffer(mb buf.MultiBuffer) error {
 	return w.Writer.WriteMultiBuffer(mb)
 }
 
+// Close and Interrupt are forwarded so that Xray's common.Close/common.Interrupt
+// still reach the underlying pipe through the wrapper.
+func (w *RateLimitedWriter) Close() error {
+	return common.Close(w.Writer)
+}
+
+func (w *RateLimitedWriter) Interrupt() {
+	common.Interrupt(w.Writer)
+}
+
 type RateLimitedReader struct {
 	buf.Reader
 	limiter *rate.Limiter
@@ -221,3 +315,124 @@ func (r *RateLimitedReader) ReadMultiBuffer() (buf.MultiBuffer, error) {
 	}
 	return mb, err
 }
+
+func (r *RateLimitedReader) Interrupt() {
+	common.Interrupt(r.Reader)
+}
+
+// linkLifetime ends a dispatched connection once both directions of its link
+// are finished: the writer was closed and the reader reached the end of the
+// stream, or either side was interrupted. Mux and XUDP sub-connections share
+// the context of the connection carrying them, so its cancellation is only
+// the last resort.
+type linkLifetime struct {
+	open     atomic.Int32
+	reader   sync.Once
+	writer   sync.Once
+	done     sync.Once
+	onDone   func()
+	stopWait func() bool
+}
+
+func newLinkLifetime(ctx context.Context, link *transport.Link, onDone func()) *linkLifetime {
+	l := &linkLifetime{onDone: onDone}
+	l.open.Store(2)
+	l.stopWait = context.AfterFunc(ctx, l.interrupt)
+	if link.Reader == nil {
+		l.readEnded()
+	}
+	if link.Writer == nil {
+		l.writeEnded()
+	}
+	return l
+}
+
+func (l *linkLifetime) readEnded() {
+	l.reader.Do(l.halfEnded)
+}
+
+func (l *linkLifetime) writeEnded() {
+	l.writer.Do(l.halfEnded)
+}
+
+func (l *linkLifetime) halfEnded() {
+	if l.open.Add(-1) == 0 {
+		l.interrupt()
+	}
+}
+
+func (l *linkLifetime) interrupt() {
+	l.done.Do(func() {
+		l.stopWait()
+		l.onDone()
+	})
+}
+
+// CountingWriter reports every MultiBuffer written through it to the shared
+// accounting pipeline.
+type CountingWriter struct {
+	buf.Writer
+	record func(n int64)
+	fail   func(err error)
+	life   *linkLifetime
+}
+
+func (w *CountingWriter) WriteMultiBuffer(mb buf.MultiBuffer) error {
+	w.record(int64(mb.Len()))
+	err := w.Writer.WriteMultiBuffer(mb)
+	if err != nil {
+		if w.fail != nil {
+			w.fail(err)
+		}
+		// The reading side is gone, nothing more gets through
+		if w.life != nil {
+			w.life.writeEnded()
+		}
+	}
+	return err
+}
+
+func (w *CountingWriter) Close() error {
+	if w.life != nil {
+		defer w.life.writeEnded()
+	}
+	return common.Close(w.Writer)
+}
+
+func (w *CountingWriter) Interrupt() {
+	if w.life != nil {
+		defer w.life.interrupt()
+	}
+	common.Interrupt(w.Writer)
+}
+
+// CountingReader is the reading counterpart of CountingWriter.
+type CountingReader struct {
+	buf.Reader
+	record func(n int64)
+	fail   func(err error)
+	life   *linkLifetime
+}
+
+func (r *CountingReader) ReadMultiBuffer() (buf.MultiBuffer, error) {
+	mb, err := r.Reader.ReadMultiBuffer()
+	if !mb.IsEmpty() {
+		r.record(int64(mb.Len()))
+	}
+	if err != nil {
+		if r.fail != nil {
+			r.fail(err)
+		}
+		if r.life != nil {
+			r.life.readEnded()
+		}
+	}
+	return mb, err
+}
+
+func (r *CountingReader) Interrupt() {
+	if r.life != nil {
+		defer r.life.interrupt()
+	}
+	common.Interrupt(r.Reader)
+}
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/common/buf"
+	"github.com/xtls/xray-core/transport"
+	"github.com/xtls/xray-core/transport/pipe"
+)
+
+func ended(done chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestLinkLifetime(t *testing.T) {
+	// A mux sub-connection: the context outlives the link
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	newLink := func() (*CountingReader, *CountingWriter, chan struct{}) {
+		upReader, upWriter := pipe.New()
+		downReader, downWriter := pipe.New()
+		done := make(chan struct{})
+		life := newLinkLifetime(ctx, &transport.Link{Reader: downReader, Writer: upWriter}, func() { close(done) })
+		r := &CountingReader{Reader: downReader, record: func(int64) {}, life: life}
+		w := &CountingWriter{Writer: upWriter, record: func(int64) {}, life: life}
+		// The far end echoes the uplink and closes when it ends
+		go func() {
+			for {
+				mb, err := upReader.ReadMultiBuffer()
+				if err != nil {
+					downWriter.Close()
+					return
+				}
+				downWriter.WriteMultiBuffer(mb)
+			}
+		}()
+		return r, w, done
+	}
+
+	r, w, done := newLink()
+	b := buf.New()
+	b.WriteString("ping")
+	w.WriteMultiBuffer(buf.MultiBuffer{b})
+	if _, err := r.ReadMultiBuffer(); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	if ended(done) {
+		t.Fatal("ended before the downlink was drained")
+	}
+	if _, err := r.ReadMultiBuffer(); err == nil {
+		t.Fatal("downlink not closed")
+	}
+	if !ended(done) {
+		t.Error("not ended after both directions finished")
+	}
+
+	r, _, done = newLink()
+	r.Interrupt()
+	if !ended(done) {
+		t.Error("not ended after an interrupt")
+	}
+
+	_, _, done = newLink()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("not ended when the context was cancelled")
+	}
+}
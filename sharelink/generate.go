@@ -0,0 +1,191 @@
+// Package sharelink converts nodes to and from the share URIs understood by
+// v2rayN, Shadowrocket, NekoBox and similar clients.
+package sharelink
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"freegfw/utils"
+)
+
+// Generate returns the share URI of the node, or "" if its protocol has
+// none. uuid is the user's UUID, which is also the password of
+// password-based protocols; username is only used by naive.
+func Generate(node utils.Node, uuid, username string) string {
+	serverType, _ := node.Server["type"].(string)
+	t := node.TLS()
+	trans := node.Transport()
+
+	u := &url.URL{
+		Host:     net.JoinHostPort(node.IP, node.Port),
+		Fragment: node.Title,
+	}
+	q := url.Values{}
+
+	switch serverType {
+	case "vmess":
+		return vmessLink(node, uuid)
+
+	case "vless", "trojan":
+		u.Scheme = serverType
+		u.User = url.User(uuid)
+		if serverType == "vless" {
+			q.Set("encryption", "none")
+			if flow, ok := node.Server["flow"].(string); ok && flow != "" {
+				q.Set("flow", flow)
+			}
+		}
+		switch {
+		case t.Reality:
+			q.Set("security", "reality")
+			q.Set("pbk", t.PublicKey)
+			if t.ShortID != "" {
+				q.Set("sid", t.ShortID)
+			}
+			// REALITY clients require a uTLS fingerprint
+			q.Set("fp", node.Fingerprint("chrome"))
+		case t.Enabled:
+			q.Set("security", "tls")
+			if fp := node.Fingerprint(""); fp != "" {
+				q.Set("fp", fp)
+			}
+		default:
+			q.Set("security", "none")
+		}
+		setTLSParams(q, t)
+		setTransportParams(q, trans)
+
+	case "shadowsocks":
+		method, _ := node.Server["method"].(string)
+		u.Scheme = "ss"
+		u.User = url.User(base64.URLEncoding.EncodeToString([]byte(method + ":" + uuid)))
+
+	case "hysteria2":
+		u.Scheme = "hy2"
+		u.User = url.User(uuid)
+		if obfs, ok := node.Server["obfs"].(map[string]interface{}); ok {
+			if typ, _ := obfs["type"].(string); typ != "" {
+				q.Set("obfs", typ)
+				if pw, _ := obfs["password"].(string); pw != "" {
+					q.Set("obfs-password", pw)
+				}
+			}
+		}
+		setTLSParams(q, t)
+		if !q.Has("alpn") && t.Enabled {
+			q.Set("alpn", "h3")
+		}
+
+	case "tuic":
+		u.Scheme = "tuic"
+		u.User = url.UserPassword(uuid, uuid)
+		cc, _ := node.Server["congestion_control"].(string)
+		if cc == "" {
+			cc = "cubic"
+		}
+		q.Set("congestion_control", cc)
+		q.Set("udp_relay_mode", "native")
+		setTLSParams(q, t)
+		if !q.Has("alpn") {
+			q.Set("alpn", "h3")
+		}
+
+	case "anytls":
+		u.Scheme = "anytls"
+		u.User = url.User(uuid)
+		setTLSParams(q, t)
+		if fp := node.Fingerprint(""); fp != "" {
+			q.Set("fp", fp)
+		}
+
+	case "naive":
+		// Shadowrocket's format, the only one naive clients agree on
+		userinfo := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s@%s", username, uuid, u.Host)))
+		q.Set("padding", "1")
+		q.Set("method", "auto")
+		q.Set("peer", t.ServerName)
+		return "http2://" + userinfo + "?" + q.Encode() + "#" + u.EscapedFragment()
+
+	default:
+		return ""
+	}
+
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func setTLSParams(q url.Values, t utils.NodeTLS) {
+	if !t.Enabled {
+		return
+	}
+	if t.ServerName != "" {
+		q.Set("sni", t.ServerName)
+	}
+	if len(t.ALPN) > 0 {
+		q.Set("alpn", strings.Join(t.ALPN, ","))
+	}
+}
+
+func setTransportParams(q url.Values, trans utils.NodeTransport) {
+	q.Set("type", trans.Type)
+	if trans.Path != "" {
+		q.Set("path", trans.Path)
+	}
+	if trans.Host != "" {
+		q.Set("host", trans.Host)
+	}
+	if trans.ServiceName != "" {
+		q.Set("serviceName", trans.ServiceName)
+	}
+	if trans.Mode != "" {
+		q.Set("mode", trans.Mode)
+	}
+	if trans.Extra != nil {
+		if b, err := json.Marshal(trans.Extra); err == nil {
+			q.Set("extra", string(b))
+		}
+	}
+}
+
+// vmessLink builds the v2rayN "vmess://" + base64(JSON) link.
+func vmessLink(node utils.Node, uuid string) string {
+	t := node.TLS()
+	trans := node.Transport()
+	v := map[string]interface{}{
+		"v":    "2",
+		"ps":   node.Title,
+		"add":  node.IP,
+		"port": node.Port,
+		"id":   uuid,
+		"aid":  "0",
+		"scy":  "auto",
+		"net":  trans.Type,
+		"type": "none",
+		"host": trans.Host,
+		"path": trans.Path,
+		"tls":  "",
+	}
+	if trans.Type == "grpc" {
+		// v2rayN carries the gRPC service name in path
+		v["path"] = trans.ServiceName
+	}
+	if t.Enabled {
+		v["tls"] = "tls"
+		if t.ServerName != "" {
+			v["sni"] = t.ServerName
+		}
+		if len(t.ALPN) > 0 {
+			v["alpn"] = strings.Join(t.ALPN, ",")
+		}
+		if fp := node.Fingerprint(""); fp != "" {
+			v["fp"] = fp
+		}
+	}
+	b, _ := json.Marshal(v)
+	return "vmess://" + base64.StdEncoding.EncodeToString(b)
+}
@@ -0,0 +1,316 @@
+package sharelink
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"freegfw/utils"
+)
+
+// Link is a parsed share link.
+type Link struct {
+	// Node holds the server in the same sing-box inbound shape that linked
+	// nodes are synced in, so it can be fed back into Generate or any of the
+	// client config builders in utils.
+	Node utils.Node
+	// Password is the credential carried by the link: the UUID for vmess,
+	// vless and tuic, the password for everything else.
+	Password string
+}
+
+// Parse reads a vmess://, vless://, trojan://, ss://, hy2://, tuic:// or
+// anytls:// link.
+func Parse(raw string) (*Link, error) {
+	raw = strings.TrimSpace(raw)
+	scheme, _, ok := strings.Cut(raw, "://")
+	if !ok {
+		return nil, errors.New("not a share link")
+	}
+
+	switch strings.ToLower(scheme) {
+	case "vmess":
+		return parseVmess(raw)
+	case "ss":
+		return parseShadowsocks(raw)
+	case "vless", "trojan", "hy2", "hysteria2", "tuic", "anytls":
+		return parseURL(raw)
+	}
+	return nil, fmt.Errorf("unsupported scheme %q", scheme)
+}
+
+func parseURL(raw string) (*Link, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if u.User == nil {
+		return nil, errors.New("missing credentials")
+	}
+	q := u.Query()
+
+	serverType := strings.ToLower(u.Scheme)
+	if serverType == "hy2" {
+		serverType = "hysteria2"
+	}
+
+	link, err := newLink(serverType, u.Hostname(), u.Port(), u.Fragment)
+	if err != nil {
+		return nil, err
+	}
+	link.Password = u.User.Username()
+	server := link.Node.Server
+
+	switch serverType {
+	case "vless", "trojan":
+		if flow := q.Get("flow"); flow != "" && serverType == "vless" {
+			server["flow"] = flow
+		}
+		security := q.Get("security")
+		if security == "" && serverType == "trojan" {
+			// Trojan is always TLS unless stated otherwise
+			security = "tls"
+		}
+		if security == "tls" || security == "reality" {
+			tls := tlsFromQuery(q)
+			if security == "reality" {
+				reality := map[string]interface{}{
+					"enabled":    true,
+					"public_key": q.Get("pbk"),
+				}
+				if sid := q.Get("sid"); sid != "" {
+					reality["short_id"] = []interface{}{sid}
+				}
+				tls["reality"] = reality
+			}
+			server["tls"] = tls
+		}
+		if transport, err := transportFromQuery(q); err != nil {
+			return nil, err
+		} else if transport != nil {
+			server["transport"] = transport
+		}
+
+	case "hysteria2":
+		server["tls"] = tlsFromQuery(q)
+		if obfs := q.Get("obfs"); obfs != "" {
+			server["obfs"] = map[string]interface{}{
+				"type":     obfs,
+				"password": q.Get("obfs-password"),
+			}
+		}
+
+	case "tuic":
+		// The password is not kept: FreeGFW servers always use the UUID
+		if cc := q.Get("congestion_control"); cc != "" {
+			server["congestion_control"] = cc
+		}
+		server["tls"] = tlsFromQuery(q)
+
+	case "anytls":
+		server["tls"] = tlsFromQuery(q)
+	}
+
+	if fp := q.Get("fp"); fp != "" {
+		link.Node.Client = map[string]interface{}{
+			"tls": map[string]interface{}{
+				"utls": map[string]interface{}{"enabled": true, "fingerprint": fp},
+			},
+		}
+	}
+	return link, nil
+}
+
+func parseVmess(raw string) (*Link, error) {
+	b, err := decodeBase64(raw[len("vmess://"):])
+	if err != nil {
+		return nil, fmt.Errorf("invalid vmess link: %w", err)
+	}
+	var v map[string]interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, fmt.Errorf("invalid vmess link: %w", err)
+	}
+	str := func(k string) string {
+		switch val := v[k].(type) {
+		case string:
+			return val
+		case float64:
+			return strconv.Itoa(int(val))
+		}
+		return ""
+	}
+
+	link, err := newLink("vmess", str("add"), str("port"), str("ps"))
+	if err != nil {
+		return nil, err
+	}
+	link.Password = str("id")
+	server := link.Node.Server
+
+	if str("tls") == "tls" {
+		tls := map[string]interface{}{"enabled": true, "server_name": str("sni")}
+		if alpn := str("alpn"); alpn != "" {
+			tls["alpn"] = splitList(alpn)
+		}
+		server["tls"] = tls
+		if fp := str("fp"); fp != "" {
+			link.Node.Client = map[string]interface{}{
+				"tls": map[string]interface{}{
+					"utls": map[string]interface{}{"enabled": true, "fingerprint": fp},
+				},
+			}
+		}
+	}
+
+	if network := str("net"); network != "" && network != "tcp" {
+		transport := map[string]interface{}{"type": network}
+		if network == "grpc" {
+			transport["service_name"] = str("path")
+		} else {
+			if p := str("path"); p != "" {
+				transport["path"] = p
+			}
+			if h := str("host"); h != "" {
+				transport["host"] = h
+			}
+		}
+		server["transport"] = transport
+	}
+	return link, nil
+}
+
+func parseShadowsocks(raw string) (*Link, error) {
+	rest := raw[len("ss://"):]
+	rest, fragment, _ := strings.Cut(rest, "#")
+	rest, _, _ = strings.Cut(rest, "?")
+	title, err := url.PathUnescape(fragment)
+	if err != nil {
+		title = fragment
+	}
+
+	var userinfo, hostport string
+	if at := strings.LastIndex(rest, "@"); at >= 0 {
+		// SIP002: ss://base64(method:password)@host:port, or the plain
+		// userinfo used by 2022 ciphers
+		userinfo, hostport = rest[:at], rest[at+1:]
+		if b, err := decodeBase64(userinfo); err == nil {
+			userinfo = string(b)
+		} else if userinfo, err = url.PathUnescape(userinfo); err != nil {
+			return nil, fmt.Errorf("invalid ss link: %w", err)
+		}
+	} else {
+		// Legacy: ss://base64(method:password@host:port)
+		b, err := decodeBase64(rest)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ss link: %w", err)
+		}
+		at := strings.LastIndex(string(b), "@")
+		if at < 0 {
+			return nil, errors.New("invalid ss link: missing server")
+		}
+		userinfo, hostport = string(b[:at]), string(b[at+1:])
+	}
+
+	method, password, ok := strings.Cut(userinfo, ":")
+	if !ok {
+		return nil, errors.New("invalid ss link: missing password")
+	}
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ss link: %w", err)
+	}
+
+	link, err := newLink("shadowsocks", host, port, title)
+	if err != nil {
+		return nil, err
+	}
+	link.Node.Server["method"] = method
+	link.Password = password
+	return link, nil
+}
+
+func newLink(serverType, host, port, title string) (*Link, error) {
+	if host == "" {
+		return nil, errors.New("missing server address")
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil || p <= 0 || p > 65535 {
+		return nil, fmt.Errorf("invalid port %q", port)
+	}
+	return &Link{
+		Node: utils.Node{
+			Title: title,
+			IP:    host,
+			Port:  port,
+			Server: map[string]interface{}{
+				"type":        serverType,
+				"listen_port": p,
+			},
+		},
+	}, nil
+}
+
+func tlsFromQuery(q url.Values) map[string]interface{} {
+	tls := map[string]interface{}{"enabled": true}
+	if sni := q.Get("sni"); sni != "" {
+		tls["server_name"] = sni
+	} else if peer := q.Get("peer"); peer != "" {
+		tls["server_name"] = peer
+	}
+	if alpn := q.Get("alpn"); alpn != "" {
+		tls["alpn"] = splitList(alpn)
+	}
+	return tls
+}
+
+func transportFromQuery(q url.Values) (map[string]interface{}, error) {
+	typ := q.Get("type")
+	if typ == "" || typ == "tcp" {
+		return nil, nil
+	}
+	transport := map[string]interface{}{"type": typ}
+	if p := q.Get("path"); p != "" {
+		transport["path"] = p
+	}
+	if h := q.Get("host"); h != "" {
+		transport["host"] = h
+	}
+	if sn := q.Get("serviceName"); sn != "" {
+		transport["service_name"] = sn
+	}
+	if mode := q.Get("mode"); mode != "" {
+		transport["mode"] = mode
+	}
+	if extra := q.Get("extra"); extra != "" {
+		var m map[string]interface{}
+		if err := json.Unmarshal([]byte(extra), &m); err != nil {
+			return nil, fmt.Errorf("invalid xhttp extra: %w", err)
+		}
+		transport["extra"] = m
+	}
+	return transport, nil
+}
+
+func splitList(s string) []interface{} {
+	var list []interface{}
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// decodeBase64 accepts standard and URL-safe base64, padded or not.
+func decodeBase64(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if strings.ContainsAny(s, "-_") {
+		return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	}
+	return base64.RawStdEncoding.DecodeString(strings.TrimRight(s, "="))
+}
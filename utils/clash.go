@@ -1,72 +1,22 @@
 package utils
 
 import (
-	"fmt"
+	"strconv"
+	"strings"
+	"time"
 )
 
-func ToClashProxy(server map[string]interface{}, ip, port, uuid, title string) map[string]interface{} {
-	serverType, _ := server["type"].(string)
+// ToClashProxy builds a mihomo (Clash.Meta) proxy for the node. It returns
+// nil for nodes mihomo cannot connect to, e.g. QUIC transports.
+func ToClashProxy(node Node, uuid, username string) map[string]interface{} {
+	serverType, _ := node.Server["type"].(string)
+	t := node.TLS()
+	trans := node.Transport()
 
 	proxy := map[string]interface{}{
-		"name":   title,
-		"server": ip,
-		"port":   port, // Clash expects int or string? Usually int.
-	}
-
-	// Helper to handle port as int
-	// In the original code port was cast to string for links.
-	// Clash yaml usually prefers int but string works often. Let's try to keep it as int if possible.
-	// But the incoming 'port' arg is string.
-	// We can leave it as is or convert.
-
-	tlsConfig, _ := server["tls"].(map[string]interface{})
-	isTLS := false
-	serverName := ""
-	isReality := false
-	realityPub := ""
-	realitySid := ""
-
-	if tlsConfig != nil && tlsConfig["enabled"] == true {
-		isTLS = true
-		serverName, _ = tlsConfig["server_name"].(string)
-		if serverName == "" {
-			serverName = ip
-		}
-
-		if reality, ok := tlsConfig["reality"].(map[string]interface{}); ok {
-			if rEnabled, ok := reality["enabled"].(bool); ok && rEnabled {
-				isReality = true
-				if pk, ok := reality["public_key"].(string); ok {
-					realityPub = pk
-				}
-				if sids, ok := reality["short_id"].([]interface{}); ok && len(sids) > 0 {
-					if sid, ok := sids[0].(string); ok {
-						realitySid = sid
-					}
-				}
-			}
-		}
-	}
-
-	transport, _ := server["transport"].(map[string]interface{})
-	netType := "tcp" // default
-	path := ""
-	host := ""
-
-	if transport != nil {
-		if t, ok := transport["type"].(string); ok && t != "" {
-			netType = t
-		}
-		if p, ok := transport["path"].(string); ok && p != "" {
-			path = p
-		}
-		if h, ok := transport["host"].(string); ok && h != "" {
-			host = h
-		} else if hVal, ok := transport["host"].([]interface{}); ok && len(hVal) > 0 {
-			if s, ok := hVal[0].(string); ok {
-				host = s
-			}
-		}
+		"name":   node.Title,
+		"server": node.IP,
+		"port":   node.PortNumber(),
 	}
 
 	switch serverType {
@@ -75,112 +25,218 @@ func ToClashProxy(server map[string]interface{}, ip, port, uuid, title string) m
 		proxy["uuid"] = uuid
 		proxy["alterId"] = 0
 		proxy["cipher"] = "auto"
-
-		if isTLS {
-			proxy["tls"] = true
-			if serverName != "" {
-				proxy["servername"] = serverName
-			}
-		}
-		proxy["network"] = netType
-		if netType == "ws" {
-			proxy["ws-opts"] = map[string]interface{}{}
-			if path != "" {
-				proxy["ws-opts"].(map[string]interface{})["path"] = path
-			}
-			if host != "" {
-				proxy["ws-opts"].(map[string]interface{})["headers"] = map[string]interface{}{"Host": host}
-			}
-		}
-
 	case "vless":
 		proxy["type"] = "vless"
 		proxy["uuid"] = uuid
-		if flow, ok := server["flow"].(string); ok && flow != "" {
+		if flow, ok := node.Server["flow"].(string); ok && flow != "" {
 			proxy["flow"] = flow
 		}
-
-		if isTLS {
-			proxy["tls"] = true
-			if serverName != "" {
-				proxy["servername"] = serverName
-			}
-			if isReality {
-				proxy["reality-opts"] = map[string]interface{}{
-					"public-key": realityPub,
-					"short-id":   realitySid,
-				}
-				proxy["client-fingerprint"] = "chrome"
-			}
-		}
-		proxy["network"] = netType
-		if netType == "ws" {
-			proxy["ws-opts"] = map[string]interface{}{}
-			if path != "" {
-				proxy["ws-opts"].(map[string]interface{})["path"] = path
-			}
-			if host != "" {
-				proxy["ws-opts"].(map[string]interface{})["headers"] = map[string]interface{}{"Host": host}
-			}
-		}
-
 	case "trojan":
 		proxy["type"] = "trojan"
 		proxy["password"] = uuid
-		if isTLS {
-			proxy["tls"] = true // Trojan is always TLS but Clash config has 'tls: true' explicit? Usually implies it.
-			// Actually standard Trojan implies TLS.
-			// Checking clash docs: type: trojan, server, port, password, udp(opt), sni(opt), alpn(opt), skip-cert-verify(opt)
-			proxy["sni"] = serverName
-		}
-
 	case "shadowsocks":
 		proxy["type"] = "ss"
-		proxy["cipher"], _ = server["method"].(string)
+		proxy["cipher"], _ = node.Server["method"].(string)
 		proxy["password"] = uuid
-
 	case "hysteria2":
 		proxy["type"] = "hysteria2"
 		proxy["password"] = uuid
-		if isTLS {
-			proxy["sni"] = serverName
-			proxy["alpn"] = []string{"h3"}
+		if obfs, ok := node.Server["obfs"].(map[string]interface{}); ok {
+			if typ, _ := obfs["type"].(string); typ != "" {
+				proxy["obfs"] = typ
+				proxy["obfs-password"], _ = obfs["password"].(string)
+			}
+		}
+		setClashPortHopping(proxy, node)
+		// Like sing-box, the client adopts the server's bandwidth unless
+		// the template client sets its own. The server's upload is the
+		// client's download and the other way round.
+		if up := clashNumber(node.Client["up_mbps"], node.Server["down_mbps"]); up > 0 {
+			proxy["up"] = formatMbps(up)
+		}
+		if down := clashNumber(node.Client["down_mbps"], node.Server["up_mbps"]); down > 0 {
+			proxy["down"] = formatMbps(down)
 		}
-
 	case "tuic":
 		proxy["type"] = "tuic"
 		proxy["uuid"] = uuid
-		proxy["password"] = uuid // TUIC uses uuid usually
-		if isTLS {
-			proxy["server-name"] = serverName
-			proxy["alpn"] = []string{"h3"}
+		proxy["password"] = uuid
+		if cc, ok := node.Server["congestion_control"].(string); ok && cc != "" {
+			proxy["congestion-controller"] = cc
 		}
-
+		proxy["udp-relay-mode"] = "native"
 	case "anytls":
 		proxy["type"] = "anytls"
 		proxy["password"] = uuid
-		proxy["client-fingerprint"] = "chrome"
 		proxy["udp"] = true
-		if isTLS && serverName != "" {
-			proxy["sni"] = serverName
-		}
-
+	case "naive":
+		// mihomo has no naive client; naive servers also accept plain
+		// HTTPS proxy requests
+		proxy["type"] = "http"
+		proxy["username"] = username
+		proxy["password"] = uuid
 	default:
 		return nil
 	}
 
-	// Parse port to int if possible for cleaner YAML
-	var portInt int
-	if _, err := fmt.Sscanf(port, "%d", &portInt); err == nil {
-		proxy["port"] = portInt
-	} else {
-		// fallback
-		proxy["port"] = port
+	if t.Enabled {
+		switch serverType {
+		case "vmess", "vless":
+			proxy["tls"] = true
+			proxy["servername"] = t.ServerName
+		case "naive":
+			proxy["tls"] = true
+			proxy["sni"] = t.ServerName
+		default:
+			proxy["sni"] = t.ServerName
+		}
+		alpn := t.ALPN
+		if len(alpn) == 0 && (serverType == "hysteria2" || serverType == "tuic") {
+			alpn = []string{"h3"}
+		}
+		if len(alpn) > 0 {
+			proxy["alpn"] = alpn
+		}
+
+		fp := node.Fingerprint("")
+		if t.Reality {
+			proxy["reality-opts"] = map[string]interface{}{
+				"public-key": t.PublicKey,
+				"short-id":   t.ShortID,
+			}
+			// REALITY requires uTLS
+			if fp == "" {
+				fp = "chrome"
+			}
+		}
+		if serverType == "anytls" && fp == "" {
+			fp = "chrome"
+		}
+		switch serverType {
+		case "hysteria2", "tuic", "naive":
+			// QUIC based protocols and mihomo's HTTP proxy cannot use uTLS
+		default:
+			if fp != "" {
+				proxy["client-fingerprint"] = fp
+			}
+		}
 	}
 
+	switch serverType {
+	case "vmess", "vless", "trojan":
+		if !setClashTransport(proxy, trans, t.Enabled) {
+			return nil
+		}
+	}
 	return proxy
 }
 
+// setClashTransport sets the mihomo network options of a V2Ray transport.
+// It returns false if mihomo does not support the transport.
+func setClashTransport(proxy map[string]interface{}, trans NodeTransport, tls bool) bool {
+	switch trans.Type {
+	case "tcp":
+		proxy["network"] = "tcp"
+	case "ws", "httpupgrade":
+		proxy["network"] = "ws"
+		opts := map[string]interface{}{}
+		if trans.Path != "" {
+			opts["path"] = trans.Path
+		}
+		if trans.Host != "" {
+			opts["headers"] = map[string]interface{}{"Host": trans.Host}
+		}
+		if trans.Type == "httpupgrade" {
+			opts["v2ray-http-upgrade"] = true
+		}
+		proxy["ws-opts"] = opts
+	case "grpc":
+		proxy["network"] = "grpc"
+		proxy["grpc-opts"] = map[string]interface{}{"grpc-service-name": trans.ServiceName}
+	case "http":
+		// sing-box's HTTP transport is HTTP/2 with TLS and HTTP/1.1
+		// without. mihomo's http network is only header obfuscation on
+		// plain TCP, so the latter has no counterpart.
+		if proxy["type"] == "trojan" || !tls {
+			return false
+		}
+		path := trans.Path
+		if path == "" {
+			path = "/"
+		}
+		proxy["network"] = "h2"
+		opts := map[string]interface{}{"path": path}
+		if trans.Host != "" {
+			opts["host"] = []string{trans.Host}
+		}
+		proxy["h2-opts"] = opts
+	case "xhttp":
+		if proxy["type"] != "vless" {
+			return false
+		}
+		proxy["network"] = "xhttp"
+		opts := map[string]interface{}{}
+		if trans.Path != "" {
+			opts["path"] = trans.Path
+		}
+		if trans.Host != "" {
+			opts["host"] = trans.Host
+		}
+		if trans.Mode != "" {
+			opts["mode"] = trans.Mode
+		}
+		proxy["xhttp-opts"] = opts
+	default:
+		return false
+	}
+	return true
+}
+
+// setClashPortHopping copies the sing-box server_ports and hop_interval of
+// a Hysteria2 node, preferring the template client's.
+func setClashPortHopping(proxy map[string]interface{}, node Node) {
+	ports, _ := node.Client["server_ports"].([]interface{})
+	if ports == nil {
+		ports, _ = node.Server["server_ports"].([]interface{})
+	}
+	var ranges []string
+	for _, p := range ports {
+		if s, ok := p.(string); ok && s != "" {
+			// sing-box writes ranges as 20000:30000, mihomo as 20000-30000
+			ranges = append(ranges, strings.ReplaceAll(s, ":", "-"))
+		}
+	}
+	if len(ranges) == 0 {
+		return
+	}
+	proxy["ports"] = strings.Join(ranges, ",")
+
+	interval, _ := node.Client["hop_interval"].(string)
+	if interval == "" {
+		interval, _ = node.Server["hop_interval"].(string)
+	}
+	if d, err := time.ParseDuration(interval); err == nil && d >= time.Second {
+		proxy["hop-interval"] = int(d.Seconds())
+	}
+}
+
+// clashNumber returns the first of the JSON numbers that is set.
+func clashNumber(values ...interface{}) float64 {
+	for _, v := range values {
+		if f, ok := v.(float64); ok {
+			return f
+		}
+		if i, ok := v.(int); ok {
+			return float64(i)
+		}
+	}
+	return 0
+}
+
+func formatMbps(mbps float64) string {
+	return strconv.FormatFloat(mbps, 'f', -1, 64) + " Mbps"
+}
+
 func GenClashConfig(proxies []map[string]interface{}) map[string]interface{} {
 	proxyNames := make([]string, 0, len(proxies))
 	for _, p := range proxies {
@@ -189,21 +245,25 @@ func GenClashConfig(proxies []map[string]interface{}) map[string]interface{} {
 		}
 	}
 
-	// Define Proxy Groups
+	// Define Proxy Groups. Clients reject groups without members, so
+	// without nodes everything goes direct.
 	groups := []map[string]interface{}{
 		{
 			"name":    "Proxy",
 			"type":    "select",
-			"proxies": append([]string{"Auto"}, proxyNames...),
+			"proxies": []string{"DIRECT"},
 		},
-		{
+	}
+	if len(proxyNames) > 0 {
+		groups[0]["proxies"] = append([]string{"Auto"}, proxyNames...)
+		groups = append(groups, map[string]interface{}{
 			"name":      "Auto",
 			"type":      "url-test",
 			"url":       "http://www.gstatic.com/generate_204",
 			"interval":  300,
 			"tolerance": 50,
 			"proxies":   proxyNames,
-		},
+		})
 	}
 
 	// Define Rules
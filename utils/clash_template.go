@@ -0,0 +1,255 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Placeholders understood by RenderClashTemplate.
+const (
+	// clashProxiesPlaceholder is an item of proxies: that stands for the
+	// user's nodes. Without it the nodes are appended to proxies.
+	clashProxiesPlaceholder = "$proxies"
+	// clashAllPlaceholder is a member of a proxy group that stands for the
+	// names of all of the user's nodes.
+	clashAllPlaceholder = "$all"
+	// clashRegionsPlaceholder is an item of proxy-groups that stands for
+	// the generated region groups, and a member of a proxy group that
+	// stands for their names.
+	clashRegionsPlaceholder = "$regions"
+)
+
+// clashRegions maps node titles to regions. A title belongs to the first
+// region whose flag, one of whose codes (as a word) or one of whose names
+// (ignoring case) it contains.
+var clashRegions = []struct {
+	Flag  string
+	Name  string
+	Codes []string
+	Names []string
+}{
+	{"🇭🇰", "Hong Kong", []string{"HK"}, []string{"hong kong", "hongkong", "香港"}},
+	{"🇹🇼", "Taiwan", []string{"TW"}, []string{"taiwan", "台湾", "台灣"}},
+	{"🇯🇵", "Japan", []string{"JP"}, []string{"japan", "tokyo", "osaka", "日本", "东京", "大阪"}},
+	{"🇸🇬", "Singapore", []string{"SG"}, []string{"singapore", "新加坡", "狮城"}},
+	{"🇰🇷", "Korea", []string{"KR"}, []string{"korea", "seoul", "韩国", "首尔"}},
+	{"🇺🇸", "United States", []string{"US"}, []string{"united states", "america", "los angeles", "san jose", "seattle", "美国"}},
+	{"🇨🇦", "Canada", []string{"CA"}, []string{"canada", "加拿大"}},
+	{"🇬🇧", "United Kingdom", []string{"GB", "UK"}, []string{"united kingdom", "london", "英国"}},
+	{"🇩🇪", "Germany", []string{"DE"}, []string{"germany", "frankfurt", "德国"}},
+	{"🇫🇷", "France", []string{"FR"}, []string{"france", "paris", "法国"}},
+	{"🇳🇱", "Netherlands", []string{"NL"}, []string{"netherlands", "amsterdam", "荷兰"}},
+	{"🇷🇺", "Russia", []string{"RU"}, []string{"russia", "moscow", "俄罗斯"}},
+	{"🇹🇷", "Turkey", []string{"TR"}, []string{"turkey", "türkiye", "istanbul", "土耳其"}},
+	{"🇮🇳", "India", []string{"IN"}, []string{"india", "mumbai", "印度"}},
+	{"🇦🇺", "Australia", []string{"AU"}, []string{"australia", "sydney", "澳大利亚", "澳洲"}},
+}
+
+// clashRegion returns the index in clashRegions of the region the title
+// names, or -1.
+func clashRegion(title string) int {
+	lower := strings.ToLower(title)
+	for i, r := range clashRegions {
+		if strings.Contains(title, r.Flag) {
+			return i
+		}
+		for _, code := range r.Codes {
+			if containsWord(title, code) {
+				return i
+			}
+		}
+		for _, name := range r.Names {
+			if strings.Contains(lower, name) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// containsWord reports whether s contains word not surrounded by letters.
+func containsWord(s, word string) bool {
+	for i := 0; ; {
+		j := strings.Index(s[i:], word)
+		if j < 0 {
+			return false
+		}
+		start, end := i+j, i+j+len(word)
+		before, _ := utf8.DecodeLastRuneInString(s[:start])
+		after, _ := utf8.DecodeRuneInString(s[end:])
+		if !unicode.IsLetter(before) && !unicode.IsLetter(after) {
+			return true
+		}
+		i = end
+	}
+}
+
+// clashRegionGroups builds one url-test group per region that has nodes,
+// in the order of clashRegions.
+func clashRegionGroups(names []string) []map[string]interface{} {
+	members := make([][]string, len(clashRegions))
+	for _, name := range names {
+		if i := clashRegion(name); i >= 0 {
+			members[i] = append(members[i], name)
+		}
+	}
+	var groups []map[string]interface{}
+	for i, m := range members {
+		if len(m) == 0 {
+			continue
+		}
+		r := clashRegions[i]
+		groups = append(groups, map[string]interface{}{
+			"name":      r.Flag + " " + r.Name,
+			"type":      "url-test",
+			"url":       "http://www.gstatic.com/generate_204",
+			"interval":  300,
+			"tolerance": 50,
+			"proxies":   m,
+		})
+	}
+	return groups
+}
+
+// RenderClashTemplate fills a Clash or mihomo profile template with the
+// user's proxies. The template is a complete profile, so ports, DNS,
+// rule-providers and rules are taken from it as they are; "$proxies",
+// "$all" and "$regions" mark where generated content goes.
+func RenderClashTemplate(tmpl string, proxies []map[string]interface{}) (map[string]interface{}, error) {
+	var cfg map[string]interface{}
+	if err := yaml.Unmarshal([]byte(tmpl), &cfg); err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		return nil, errors.New("template is empty")
+	}
+
+	names := make([]string, 0, len(proxies))
+	for _, p := range proxies {
+		if name, ok := p["name"].(string); ok {
+			names = append(names, name)
+		}
+	}
+	regions := clashRegionGroups(names)
+	regionNames := make([]string, len(regions))
+	for i, g := range regions {
+		regionNames[i] = g["name"].(string)
+	}
+
+	static, _ := cfg["proxies"].([]interface{})
+	out := make([]interface{}, 0, len(static)+len(proxies))
+	placed := false
+	for _, p := range static {
+		if p == clashProxiesPlaceholder {
+			for _, proxy := range proxies {
+				out = append(out, proxy)
+			}
+			placed = true
+			continue
+		}
+		out = append(out, p)
+	}
+	if !placed {
+		for _, proxy := range proxies {
+			out = append(out, proxy)
+		}
+	}
+	cfg["proxies"] = out
+
+	if groups, ok := cfg["proxy-groups"].([]interface{}); ok {
+		var outGroups []interface{}
+		for _, g := range groups {
+			if g == clashRegionsPlaceholder {
+				for _, r := range regions {
+					outGroups = append(outGroups, r)
+				}
+				continue
+			}
+			group, ok := g.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("invalid proxy group %v", g)
+			}
+			if members, ok := group["proxies"].([]interface{}); ok {
+				group["proxies"] = expandClashMembers(members, names, regionNames)
+				// Clients reject groups without members
+				if len(group["proxies"].([]interface{})) == 0 && group["use"] == nil {
+					group["proxies"] = []interface{}{"DIRECT"}
+				}
+			}
+			outGroups = append(outGroups, group)
+		}
+		cfg["proxy-groups"] = outGroups
+	}
+	return cfg, nil
+}
+
+func expandClashMembers(members []interface{}, names, regionNames []string) []interface{} {
+	out := make([]interface{}, 0, len(members))
+	for _, m := range members {
+		switch m {
+		case clashAllPlaceholder:
+			for _, n := range names {
+				out = append(out, n)
+			}
+		case clashRegionsPlaceholder:
+			for _, n := range regionNames {
+				out = append(out, n)
+			}
+		default:
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// CheckClashConfig reports proxy groups without members, members that name
+// no proxy or group and RULE-SET rules that name no rule provider.
+func CheckClashConfig(cfg map[string]interface{}) error {
+	known := map[string]bool{"DIRECT": true, "REJECT": true, "REJECT-DROP": true, "PASS": true, "COMPATIBLE": true}
+	proxies, _ := cfg["proxies"].([]interface{})
+	for _, p := range proxies {
+		if m, ok := p.(map[string]interface{}); ok {
+			if name, ok := m["name"].(string); ok {
+				known[name] = true
+			}
+		}
+	}
+	groups, _ := cfg["proxy-groups"].([]interface{})
+	for _, g := range groups {
+		if m, ok := g.(map[string]interface{}); ok {
+			if name, ok := m["name"].(string); ok {
+				known[name] = true
+			}
+		}
+	}
+	for _, g := range groups {
+		m, _ := g.(map[string]interface{})
+		members, _ := m["proxies"].([]interface{})
+		if len(members) == 0 && m["use"] == nil && m["include-all"] != true && m["include-all-proxies"] != true {
+			return fmt.Errorf("proxy group %v has no proxies", m["name"])
+		}
+		for _, member := range members {
+			if name, _ := member.(string); !known[name] {
+				return fmt.Errorf("proxy group %v: unknown proxy or group %v", m["name"], member)
+			}
+		}
+	}
+
+	providers, _ := cfg["rule-providers"].(map[string]interface{})
+	rules, _ := cfg["rules"].([]interface{})
+	for _, r := range rules {
+		rule, _ := r.(string)
+		fields := strings.Split(rule, ",")
+		if len(fields) >= 2 && strings.TrimSpace(fields[0]) == "RULE-SET" {
+			if _, ok := providers[strings.TrimSpace(fields[1])]; !ok {
+				return fmt.Errorf("rule %q: unknown rule provider %q", rule, strings.TrimSpace(fields[1]))
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,299 @@
+package utils
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// clashSchema describes the proxy fields mihomo accepts for one type, as
+// declared by its adapter option structs. The http network (http-opts) is
+// left out: it is header obfuscation on plain TCP, which no sing-box
+// inbound speaks.
+type clashSchema struct {
+	required []string
+	allowed  []string
+	networks []string
+}
+
+var clashCommonFields = []string{"name", "type", "server", "port", "udp", "ip-version", "interface-name", "routing-mark", "tfo", "mptcp", "dialer-proxy", "smux"}
+
+var clashTLSFields = []string{"tls", "sni", "servername", "alpn", "skip-cert-verify", "fingerprint", "client-fingerprint", "reality-opts"}
+
+var clashSchemas = map[string]clashSchema{
+	"vmess": {
+		required: []string{"uuid", "alterId", "cipher"},
+		allowed:  []string{"uuid", "alterId", "cipher", "network", "ws-opts", "h2-opts", "grpc-opts", "packet-encoding", "global-padding", "authenticated-length"},
+		networks: []string{"tcp", "ws", "h2", "grpc"},
+	},
+	"vless": {
+		required: []string{"uuid"},
+		allowed:  []string{"uuid", "flow", "encryption", "network", "ws-opts", "h2-opts", "grpc-opts", "xhttp-opts", "packet-encoding"},
+		networks: []string{"tcp", "ws", "h2", "grpc", "xhttp"},
+	},
+	"trojan": {
+		required: []string{"password"},
+		allowed:  []string{"password", "network", "ws-opts", "grpc-opts", "ss-opts"},
+		networks: []string{"tcp", "ws", "grpc"},
+	},
+	"ss": {
+		required: []string{"cipher", "password"},
+		allowed:  []string{"cipher", "password", "plugin", "plugin-opts", "udp-over-tcp"},
+	},
+	"hysteria2": {
+		required: []string{"password"},
+		allowed:  []string{"password", "ports", "hop-interval", "up", "down", "obfs", "obfs-password", "ca", "cwnd"},
+	},
+	"tuic": {
+		required: []string{"uuid", "password"},
+		allowed:  []string{"uuid", "password", "congestion-controller", "udp-relay-mode", "reduce-rtt", "heartbeat-interval", "disable-sni"},
+	},
+	"anytls": {
+		required: []string{"password"},
+		allowed:  []string{"password", "idle-session-check-interval", "idle-session-timeout", "min-idle-session"},
+	},
+	"http": {
+		allowed: []string{"username", "password", "headers"},
+	},
+}
+
+var clashBandwidth = regexp.MustCompile(`^[0-9]+(\.[0-9]+)? Mbps$`)
+
+// checkClashProxy reports fields mihomo would reject or ignore.
+func checkClashProxy(t *testing.T, proxy map[string]interface{}) {
+	t.Helper()
+	typ, _ := proxy["type"].(string)
+	schema, ok := clashSchemas[typ]
+	if !ok {
+		t.Fatalf("unknown proxy type %q", typ)
+	}
+	allowed := map[string]bool{}
+	for _, fields := range [][]string{clashCommonFields, clashTLSFields, schema.allowed} {
+		for _, f := range fields {
+			allowed[f] = true
+		}
+	}
+	for k := range proxy {
+		if !allowed[k] {
+			t.Errorf("%s: unknown field %q", typ, k)
+		}
+	}
+	for _, f := range append([]string{"name", "server", "port"}, schema.required...) {
+		if _, ok := proxy[f]; !ok {
+			t.Errorf("%s: missing %q", typ, f)
+		}
+	}
+
+	for _, f := range []string{"port", "alterId", "hop-interval"} {
+		if v, ok := proxy[f]; ok {
+			if n, ok := v.(int); !ok || n < 0 {
+				t.Errorf("%s: %s = %v, want a non-negative integer", typ, f, v)
+			}
+		}
+	}
+	for _, f := range []string{"tls", "udp", "skip-cert-verify"} {
+		if v, ok := proxy[f]; ok {
+			if _, ok := v.(bool); !ok {
+				t.Errorf("%s: %s = %v, want a boolean", typ, f, v)
+			}
+		}
+	}
+	for _, f := range []string{"up", "down"} {
+		if v, ok := proxy[f]; ok {
+			if s, _ := v.(string); !clashBandwidth.MatchString(s) {
+				t.Errorf("%s: %s = %v, want a bandwidth like \"100 Mbps\"", typ, f, v)
+			}
+		}
+	}
+	if v, ok := proxy["alpn"]; ok {
+		if list, ok := v.([]interface{}); !ok || len(list) == 0 {
+			t.Errorf("%s: alpn = %v, want a list", typ, v)
+		}
+	}
+	if network, ok := proxy["network"]; ok {
+		valid := false
+		for _, n := range schema.networks {
+			valid = valid || network == n
+		}
+		if !valid {
+			t.Errorf("%s: network %v is not supported", typ, network)
+		}
+		if opts := network.(string) + "-opts"; network != "tcp" {
+			if _, ok := proxy[opts]; !ok {
+				t.Errorf("%s: network %v without %s", typ, network, opts)
+			}
+		}
+	}
+	if v, ok := proxy["reality-opts"]; ok {
+		opts, _ := v.(map[string]interface{})
+		key, _ := opts["public-key"].(string)
+		if b, err := base64.RawURLEncoding.DecodeString(key); err != nil || len(b) != 32 {
+			t.Errorf("%s: invalid reality public-key %q", typ, key)
+		}
+		if sid, _ := opts["short-id"].(string); len(sid) > 16 {
+			t.Errorf("%s: reality short-id %q is too long", typ, sid)
+		}
+		if proxy["client-fingerprint"] == nil {
+			t.Errorf("%s: REALITY without client-fingerprint", typ)
+		}
+	}
+}
+
+// templateNodes returns a node for every built-in template, once as the
+// local node (with the template client) and once as a linked node.
+func templateNodes(t *testing.T) map[string]Node {
+	t.Helper()
+	files, err := filepath.Glob("../services/templates/*.json")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no templates found: %v", err)
+	}
+	nodes := map[string]Node{}
+	for _, file := range files {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var tmpl struct {
+			Server map[string]interface{} `json:"server"`
+			Client map[string]interface{} `json:"client"`
+		}
+		if err := json.Unmarshal(b, &tmpl); err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+		// The keys are filled in when the template is installed
+		if tls, ok := tmpl.Server["tls"].(map[string]interface{}); ok {
+			if reality, ok := tls["reality"].(map[string]interface{}); ok {
+				reality["public_key"] = "Ek7c1Vdq3VQpJ2V5zN6kYJk2m0R7pH8sXw9aLr4fT1o"
+				reality["short_id"] = []interface{}{"6ba85179e30d4fc2"}
+			}
+		}
+		name := strings.TrimSuffix(filepath.Base(file), ".json")
+		nodes[name+" (local)"] = Node{Title: name + " local", IP: "203.0.113.7", Port: "443", Server: tmpl.Server, Client: tmpl.Client}
+		nodes[name+" (linked)"] = Node{Title: name + " linked", IP: "203.0.113.8", Port: "443", Server: copyMap(tmpl.Server)}
+	}
+	return nodes
+}
+
+func TestClashProxySchema(t *testing.T) {
+	for name, node := range templateNodes(t) {
+		t.Run(name, func(t *testing.T) {
+			proxy := ToClashProxy(node, "6f1a8e2c-4b7d-4c3e-9a51-2d8f0b6e7c19", "alice")
+			if proxy == nil {
+				t.Fatal("no proxy generated")
+			}
+			// Check the YAML clients actually read, not the Go map
+			out, err := yaml.Marshal(GenClashConfig([]map[string]interface{}{proxy}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var cfg map[string]interface{}
+			if err := yaml.Unmarshal(out, &cfg); err != nil {
+				t.Fatal(err)
+			}
+			if err := CheckClashConfig(cfg); err != nil {
+				t.Error(err)
+			}
+			proxies, _ := cfg["proxies"].([]interface{})
+			if len(proxies) != 1 {
+				t.Fatalf("got %d proxies, want 1", len(proxies))
+			}
+			checkClashProxy(t, proxies[0].(map[string]interface{}))
+		})
+	}
+}
+
+func TestClashHysteria2Bandwidth(t *testing.T) {
+	node := Node{
+		Title: "hy2",
+		IP:    "203.0.113.7",
+		Port:  "443",
+		Server: map[string]interface{}{
+			"type":      "hysteria2",
+			"up_mbps":   float64(100),
+			"down_mbps": float64(20),
+		},
+	}
+	proxy := ToClashProxy(node, "password", "")
+	// The server uploads what the client downloads
+	if proxy["up"] != "20 Mbps" || proxy["down"] != "100 Mbps" {
+		t.Errorf("up, down = %v, %v; want 20 Mbps, 100 Mbps", proxy["up"], proxy["down"])
+	}
+
+	node.Client = map[string]interface{}{"up_mbps": float64(50), "down_mbps": float64(200)}
+	proxy = ToClashProxy(node, "password", "")
+	if proxy["up"] != "50 Mbps" || proxy["down"] != "200 Mbps" {
+		t.Errorf("up, down = %v, %v; want the template client's 50 Mbps, 200 Mbps", proxy["up"], proxy["down"])
+	}
+}
+
+func TestClashTransports(t *testing.T) {
+	for _, tc := range []struct {
+		typ       string
+		transport string
+		tls       bool
+		network   string // "" if the node must be skipped
+	}{
+		{"vmess", "ws", false, "ws"},
+		{"vmess", "httpupgrade", false, "ws"},
+		{"vmess", "grpc", true, "grpc"},
+		{"vmess", "http", true, "h2"},
+		{"vmess", "http", false, ""},
+		{"vless", "http", true, "h2"},
+		{"vless", "http", false, ""},
+		{"vless", "xhttp", true, "xhttp"},
+		{"trojan", "http", true, ""},
+		{"vmess", "xhttp", true, ""},
+		{"vmess", "quic", true, ""},
+	} {
+		server := map[string]interface{}{
+			"type":      tc.typ,
+			"transport": map[string]interface{}{"type": tc.transport, "path": "/p", "host": "example.com"},
+		}
+		if tc.tls {
+			server["tls"] = map[string]interface{}{"enabled": true, "server_name": "example.com"}
+		}
+		node := Node{Title: "n", IP: "203.0.113.7", Port: "443", Server: server}
+		proxy := ToClashProxy(node, "6f1a8e2c-4b7d-4c3e-9a51-2d8f0b6e7c19", "alice")
+		name := tc.typ + "+" + tc.transport
+		if tc.tls {
+			name += "+tls"
+		}
+		if tc.network == "" {
+			if proxy != nil {
+				t.Errorf("%s: got %v, want the node skipped", name, proxy)
+			}
+			continue
+		}
+		if proxy == nil {
+			t.Errorf("%s: node skipped", name)
+			continue
+		}
+		if proxy["network"] != tc.network {
+			t.Errorf("%s: network = %v, want %s", name, proxy["network"], tc.network)
+		}
+		out, _ := yaml.Marshal(proxy)
+		var m map[string]interface{}
+		yaml.Unmarshal(out, &m)
+		checkClashProxy(t, m)
+	}
+}
+
+func TestClashConfigWithoutNodes(t *testing.T) {
+	out, err := yaml.Marshal(GenClashConfig(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cfg map[string]interface{}
+	if err := yaml.Unmarshal(out, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckClashConfig(cfg); err != nil {
+		t.Error(err)
+	}
+}
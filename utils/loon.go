@@ -0,0 +1,101 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ToLoonProxy builds a Loon [Proxy] line for the node. It returns "" for
+// nodes Loon cannot connect to: it has no TUIC or naive client, and of the
+// V2Ray transports only WebSocket is supported.
+func ToLoonProxy(node Node, uuid string) string {
+	serverType, _ := node.Server["type"].(string)
+	t := node.TLS()
+	trans := node.Transport()
+	if trans.Type != "tcp" && trans.Type != "ws" {
+		return ""
+	}
+
+	params := []string{node.IP, node.Port}
+	switch serverType {
+	case "shadowsocks":
+		method, _ := node.Server["method"].(string)
+		params = append([]string{"Shadowsocks"}, params...)
+		params = append(params, method, fmt.Sprintf("%q", uuid), "udp=true")
+		return iniName(node.Title) + " = " + strings.Join(params, ",")
+	case "vmess":
+		params = append([]string{"vmess"}, params...)
+		params = append(params, "auto", fmt.Sprintf("%q", uuid))
+	case "vless":
+		params = append([]string{"VLESS"}, params...)
+		params = append(params, fmt.Sprintf("%q", uuid))
+		if flow, ok := node.Server["flow"].(string); ok && flow != "" {
+			params = append(params, "flow="+flow)
+		}
+	case "trojan":
+		params = append([]string{"trojan"}, params...)
+		params = append(params, fmt.Sprintf("%q", uuid))
+	case "hysteria2", "anytls":
+		name := map[string]string{"hysteria2": "Hysteria2", "anytls": "AnyTLS"}[serverType]
+		params = append([]string{name}, params...)
+		params = append(params, fmt.Sprintf("%q", uuid), "udp=true")
+		if t.ServerName != "" {
+			params = append(params, "sni="+t.ServerName)
+		}
+		return iniName(node.Title) + " = " + strings.Join(params, ",")
+	default:
+		return ""
+	}
+
+	params = append(params, "transport="+trans.Type)
+	if trans.Type == "ws" {
+		if trans.Path != "" {
+			params = append(params, "path="+trans.Path)
+		}
+		if trans.Host != "" {
+			params = append(params, "host="+trans.Host)
+		}
+	}
+	if t.Enabled {
+		if serverType != "trojan" {
+			params = append(params, "over-tls=true")
+		}
+		params = append(params, "sni="+t.ServerName)
+		if t.Reality {
+			params = append(params, fmt.Sprintf("public-key=%q", t.PublicKey), "short-id="+t.ShortID)
+		}
+	}
+	params = append(params, "udp=true")
+
+	return iniName(node.Title) + " = " + strings.Join(params, ",")
+}
+
+// GenLoonConfig wraps proxy lines into a complete Loon profile with a
+// select group, an auto url-test group and mainland China rules.
+func GenLoonConfig(proxies []string) string {
+	var b strings.Builder
+	b.WriteString("[General]\n")
+	b.WriteString("dns-server = system, 223.5.5.5, 119.29.29.29\n")
+	b.WriteString("skip-proxy = 127.0.0.1, 192.168.0.0/16, 10.0.0.0/8, 172.16.0.0/12, localhost, *.local\n")
+	b.WriteString("internet-test-url = http://www.gstatic.com/generate_204\n")
+	b.WriteString("proxy-test-url = http://www.gstatic.com/generate_204\n\n")
+
+	b.WriteString("[Proxy]\n")
+	for _, p := range proxies {
+		b.WriteString(p + "\n")
+	}
+
+	names := iniProxyNames(proxies)
+	b.WriteString("\n[Proxy Group]\n")
+	b.WriteString("Proxy = select," + strings.Join(append([]string{"Auto"}, names...), ",") + "\n")
+	if len(names) > 0 {
+		b.WriteString("Auto = url-test," + strings.Join(names, ",") + ",url=http://www.gstatic.com/generate_204,interval=600,tolerance=50\n")
+	} else {
+		b.WriteString("Auto = select,DIRECT\n")
+	}
+
+	b.WriteString("\n[Rule]\n")
+	b.WriteString("GEOIP,CN,DIRECT\n")
+	b.WriteString("FINAL,Proxy\n")
+	return b.String()
+}
@@ -0,0 +1,18 @@
+package utils
+
+import "testing"
+
+func TestLoonProxy(t *testing.T) {
+	checkGolden(t, ToLoonProxy, map[string]string{
+		"shadowsocks":   `ss = Shadowsocks,203.0.113.7,8388,2022-blake3-aes-128-gcm,"6f1a8e2c-4b7d-4c3e-9a51-2d8f0b6e7c19",udp=true`,
+		"vmess":         `vmess = vmess,203.0.113.7,10086,auto,"6f1a8e2c-4b7d-4c3e-9a51-2d8f0b6e7c19",transport=tcp,udp=true`,
+		"vmess ws tls":  `vmess ws = vmess,203.0.113.7,443,auto,"6f1a8e2c-4b7d-4c3e-9a51-2d8f0b6e7c19",transport=ws,path=/ws,host=cdn.example.com,over-tls=true,sni=example.com,udp=true`,
+		"vmess grpc":    "",
+		"vless reality": `reality = VLESS,203.0.113.7,443,"6f1a8e2c-4b7d-4c3e-9a51-2d8f0b6e7c19",flow=xtls-rprx-vision,transport=tcp,over-tls=true,sni=www.example.com,public-key="Ek7c1Vdq3VQpJ2V5zN6kYJk2m0R7pH8sXw9aLr4fT1o",short-id=6ba85179e30d4fc2,udp=true`,
+		"trojan":        `trojan = trojan,2001:db8::7,443,"6f1a8e2c-4b7d-4c3e-9a51-2d8f0b6e7c19",transport=tcp,sni=example.com,udp=true`,
+		"hysteria2":     `hy2 = Hysteria2,203.0.113.7,443,"6f1a8e2c-4b7d-4c3e-9a51-2d8f0b6e7c19",udp=true,sni=example.com`,
+		"tuic":          "",
+		"anytls":        `anytls = AnyTLS,203.0.113.7,443,"6f1a8e2c-4b7d-4c3e-9a51-2d8f0b6e7c19",udp=true,sni=example.com`,
+		"naive":         "",
+	})
+}
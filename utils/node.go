@@ -0,0 +1,150 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Node is one server listed in a subscription: the local node or a linked
+// remote node.
+type Node struct {
+	Title string
+	IP    string
+	Port  string
+	// Server is the sing-box style inbound config of the node.
+	Server map[string]interface{}
+	// Client is the template's client outbound. It is only known for the
+	// local node; remote nodes are derived from Server.
+	Client map[string]interface{}
+}
+
+// PortNumber returns the port as an int, or 0 if it is not numeric.
+func (n Node) PortNumber() int {
+	var p int
+	fmt.Sscanf(n.Port, "%d", &p)
+	return p
+}
+
+// NodeTLS is the client-relevant part of a node's TLS settings.
+type NodeTLS struct {
+	Enabled    bool
+	ServerName string
+	ALPN       []string
+	Reality    bool
+	PublicKey  string
+	ShortID    string
+}
+
+// TLS returns the node's TLS settings as a client needs them.
+func (n Node) TLS() NodeTLS {
+	var t NodeTLS
+	tlsConfig, _ := n.Server["tls"].(map[string]interface{})
+	if tlsConfig == nil || tlsConfig["enabled"] != true {
+		return t
+	}
+	t.Enabled = true
+	t.ServerName, _ = tlsConfig["server_name"].(string)
+	if t.ServerName == "" {
+		t.ServerName = n.IP
+	}
+	if alpn, ok := tlsConfig["alpn"].([]interface{}); ok {
+		for _, a := range alpn {
+			if s, ok := a.(string); ok {
+				t.ALPN = append(t.ALPN, s)
+			}
+		}
+	}
+	if reality, ok := tlsConfig["reality"].(map[string]interface{}); ok && reality["enabled"] == true {
+		t.Reality = true
+		t.PublicKey, _ = reality["public_key"].(string)
+		switch sid := reality["short_id"].(type) {
+		case []interface{}:
+			if len(sid) > 0 {
+				t.ShortID, _ = sid[0].(string)
+			}
+		case string:
+			t.ShortID = sid
+		}
+	}
+	return t
+}
+
+// Fingerprint returns the uTLS fingerprint of the template client, or def.
+func (n Node) Fingerprint(def string) string {
+	if tlsConfig, ok := n.Client["tls"].(map[string]interface{}); ok {
+		if utls, ok := tlsConfig["utls"].(map[string]interface{}); ok {
+			if fp, ok := utls["fingerprint"].(string); ok && fp != "" {
+				return fp
+			}
+		}
+	}
+	return def
+}
+
+// NodeTransport is the client-relevant part of a node's V2Ray transport.
+type NodeTransport struct {
+	Type        string
+	Path        string
+	Host        string
+	ServiceName string
+	// Mode and Extra are only set for XHTTP.
+	Mode  string
+	Extra map[string]interface{}
+}
+
+// Transport returns the node's V2Ray transport, "tcp" when it has none.
+func (n Node) Transport() NodeTransport {
+	t := NodeTransport{Type: "tcp"}
+	transport, _ := n.Server["transport"].(map[string]interface{})
+	if transport == nil {
+		return t
+	}
+	if v, ok := transport["type"].(string); ok && v != "" {
+		t.Type = v
+	}
+	t.Path, _ = transport["path"].(string)
+	t.ServiceName, _ = transport["service_name"].(string)
+	t.Mode, _ = transport["mode"].(string)
+	t.Extra, _ = transport["extra"].(map[string]interface{})
+	switch h := transport["host"].(type) {
+	case string:
+		t.Host = h
+	case []interface{}:
+		if len(h) > 0 {
+			t.Host, _ = h[0].(string)
+		}
+	}
+	if t.Host == "" {
+		if headers, ok := transport["headers"].(map[string]interface{}); ok {
+			t.Host, _ = headers["Host"].(string)
+		}
+	}
+	return t
+}
+
+// copyMap returns a deep copy of a JSON-like map.
+func copyMap(m map[string]interface{}) map[string]interface{} {
+	if m == nil {
+		return nil
+	}
+	b, _ := json.Marshal(m)
+	var res map[string]interface{}
+	json.Unmarshal(b, &res)
+	return res
+}
+
+// UniqueTitles suffixes repeated node titles with a counter, since clients
+// use the title as the outbound tag or proxy name. The counter skips titles
+// already in use, so "a", "a", "a 2" becomes "a", "a 2", "a 2 2" rather than
+// repeating "a 2".
+func UniqueTitles(nodes []Node) {
+	used := make(map[string]bool, len(nodes))
+	for i := range nodes {
+		title := nodes[i].Title
+		for n := 2; used[title]; n++ {
+			title = fmt.Sprintf("%s %d", nodes[i].Title, n)
+		}
+		used[title] = true
+		nodes[i].Title = title
+	}
+}
@@ -0,0 +1,69 @@
+package utils
+
+import "strings"
+
+// ToQuantumultXServer builds a Quantumult X server line for the node. It
+// returns "" for nodes Quantumult X cannot connect to: it has no hysteria2,
+// TUIC, AnyTLS or naive client, and of the V2Ray transports only WebSocket
+// is supported.
+func ToQuantumultXServer(node Node, uuid string) string {
+	serverType, _ := node.Server["type"].(string)
+	t := node.TLS()
+	trans := node.Transport()
+	if trans.Type != "tcp" && trans.Type != "ws" {
+		return ""
+	}
+
+	var params []string
+	addr := node.IP + ":" + node.Port
+	if strings.Contains(node.IP, ":") {
+		addr = "[" + node.IP + "]:" + node.Port
+	}
+
+	switch serverType {
+	case "shadowsocks":
+		method, _ := node.Server["method"].(string)
+		params = append(params, "shadowsocks="+addr, "method="+method, "password="+uuid, "udp-relay=true")
+	case "vmess":
+		params = append(params, "vmess="+addr, "method=chacha20-poly1305", "password="+uuid)
+	case "vless":
+		params = append(params, "vless="+addr, "method=none", "password="+uuid)
+		if flow, ok := node.Server["flow"].(string); ok && flow != "" {
+			params = append(params, "vless-flow="+flow)
+		}
+	case "trojan":
+		params = append(params, "trojan="+addr, "password="+uuid)
+	default:
+		return ""
+	}
+
+	switch {
+	case trans.Type == "ws":
+		obfs := "ws"
+		if t.Enabled {
+			obfs = "wss"
+		}
+		params = append(params, "obfs="+obfs)
+		if host := trans.Host; host != "" || t.ServerName != "" {
+			if host == "" {
+				host = t.ServerName
+			}
+			params = append(params, "obfs-host="+host)
+		}
+		if trans.Path != "" {
+			params = append(params, "obfs-uri="+trans.Path)
+		}
+	case t.Enabled && serverType == "trojan":
+		params = append(params, "over-tls=true", "tls-host="+t.ServerName)
+	case t.Enabled:
+		params = append(params, "obfs=over-tls", "obfs-host="+t.ServerName)
+	}
+	if t.Reality {
+		params = append(params, "reality-base64-pubkey="+t.PublicKey, "reality-hex-shortid="+t.ShortID)
+	}
+	if t.Enabled && serverType != "shadowsocks" {
+		params = append(params, "tls-verification=true")
+	}
+
+	return strings.Join(append(params, "tag="+iniName(node.Title)), ", ")
+}
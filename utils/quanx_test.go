@@ -0,0 +1,18 @@
+package utils
+
+import "testing"
+
+func TestQuantumultXServer(t *testing.T) {
+	checkGolden(t, ToQuantumultXServer, map[string]string{
+		"shadowsocks":   "shadowsocks=203.0.113.7:8388, method=2022-blake3-aes-128-gcm, password=6f1a8e2c-4b7d-4c3e-9a51-2d8f0b6e7c19, udp-relay=true, tag=ss",
+		"vmess":         "vmess=203.0.113.7:10086, method=chacha20-poly1305, password=6f1a8e2c-4b7d-4c3e-9a51-2d8f0b6e7c19, tag=vmess",
+		"vmess ws tls":  "vmess=203.0.113.7:443, method=chacha20-poly1305, password=6f1a8e2c-4b7d-4c3e-9a51-2d8f0b6e7c19, obfs=wss, obfs-host=cdn.example.com, obfs-uri=/ws, tls-verification=true, tag=vmess ws",
+		"vmess grpc":    "",
+		"vless reality": "vless=203.0.113.7:443, method=none, password=6f1a8e2c-4b7d-4c3e-9a51-2d8f0b6e7c19, vless-flow=xtls-rprx-vision, obfs=over-tls, obfs-host=www.example.com, reality-base64-pubkey=Ek7c1Vdq3VQpJ2V5zN6kYJk2m0R7pH8sXw9aLr4fT1o, reality-hex-shortid=6ba85179e30d4fc2, tls-verification=true, tag=reality",
+		"trojan":        "trojan=[2001:db8::7]:443, password=6f1a8e2c-4b7d-4c3e-9a51-2d8f0b6e7c19, over-tls=true, tls-host=example.com, tls-verification=true, tag=trojan",
+		"hysteria2":     "",
+		"tuic":          "",
+		"anytls":        "",
+		"naive":         "",
+	})
+}
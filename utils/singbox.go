@@ -0,0 +1,240 @@
+package utils
+
+// ToSingboxOutbound builds a sing-box client outbound for the node. The
+// template client block is used as the base when known, so template-level
+// options such as the uTLS fingerprint or bandwidth hints are kept. It
+// returns nil for nodes sing-box cannot connect to, e.g. XHTTP transports.
+func ToSingboxOutbound(node Node, uuid, username string) map[string]interface{} {
+	serverType, _ := node.Server["type"].(string)
+	trans := node.Transport()
+	switch trans.Type {
+	case "tcp", "ws", "grpc", "http", "httpupgrade", "quic":
+	default:
+		return nil
+	}
+
+	out := copyMap(node.Client)
+	if out == nil || out["type"] != serverType {
+		out = map[string]interface{}{}
+	}
+	out["type"] = serverType
+	out["tag"] = node.Title
+	out["server"] = node.IP
+	out["server_port"] = node.PortNumber()
+
+	switch serverType {
+	case "vless":
+		out["uuid"] = uuid
+		if flow, ok := node.Server["flow"].(string); ok && flow != "" {
+			out["flow"] = flow
+		} else {
+			delete(out, "flow")
+		}
+		out["packet_encoding"] = "xudp"
+	case "vmess":
+		out["uuid"] = uuid
+		out["security"] = "auto"
+		out["alter_id"] = 0
+	case "trojan", "anytls":
+		out["password"] = uuid
+	case "shadowsocks":
+		out["method"] = node.Server["method"]
+		out["password"] = uuid
+	case "hysteria2":
+		out["password"] = uuid
+		// The server's upload is the client's download and the other way
+		// round
+		for k, server := range map[string]string{"up_mbps": "down_mbps", "down_mbps": "up_mbps"} {
+			if _, ok := out[k]; !ok && node.Server[server] != nil {
+				out[k] = node.Server[server]
+			}
+		}
+	case "tuic":
+		out["uuid"] = uuid
+		out["password"] = uuid
+		if cc, ok := node.Server["congestion_control"].(string); ok && cc != "" {
+			out["congestion_control"] = cc
+		}
+	case "naive":
+		out["username"] = username
+		out["password"] = uuid
+	default:
+		return nil
+	}
+
+	if t := node.TLS(); t.Enabled {
+		tls := map[string]interface{}{
+			"enabled":     true,
+			"server_name": t.ServerName,
+		}
+		if len(t.ALPN) > 0 {
+			tls["alpn"] = t.ALPN
+		}
+		fp := node.Fingerprint("")
+		if serverType == "hysteria2" || serverType == "tuic" {
+			// QUIC based protocols cannot use uTLS
+			fp = ""
+		}
+		if t.Reality {
+			tls["reality"] = map[string]interface{}{
+				"enabled":    true,
+				"public_key": t.PublicKey,
+				"short_id":   t.ShortID,
+			}
+			// REALITY requires uTLS in sing-box
+			if fp == "" {
+				fp = "chrome"
+			}
+		}
+		if fp != "" {
+			tls["utls"] = map[string]interface{}{
+				"enabled":     true,
+				"fingerprint": fp,
+			}
+		}
+		out["tls"] = tls
+	} else {
+		delete(out, "tls")
+	}
+
+	if trans.Type != "tcp" {
+		transport := map[string]interface{}{"type": trans.Type}
+		switch trans.Type {
+		case "ws", "httpupgrade":
+			if trans.Path != "" {
+				transport["path"] = trans.Path
+			}
+			if trans.Host != "" {
+				if trans.Type == "ws" {
+					transport["headers"] = map[string]interface{}{"Host": trans.Host}
+				} else {
+					transport["host"] = trans.Host
+				}
+			}
+		case "http":
+			if trans.Path != "" {
+				transport["path"] = trans.Path
+			}
+			if trans.Host != "" {
+				transport["host"] = []string{trans.Host}
+			}
+		case "grpc":
+			if trans.ServiceName != "" {
+				transport["service_name"] = trans.ServiceName
+			}
+		}
+		out["transport"] = transport
+	} else {
+		delete(out, "transport")
+	}
+
+	return out
+}
+
+// GenSingboxConfig wraps node outbounds into a complete sing-box client
+// profile: TUN and mixed inbounds, a selector with a urltest group, split
+// DNS and rules that keep private and mainland China traffic direct.
+func GenSingboxConfig(outbounds []map[string]interface{}) map[string]interface{} {
+	tags := make([]string, 0, len(outbounds))
+	for _, o := range outbounds {
+		if tag, ok := o["tag"].(string); ok {
+			tags = append(tags, tag)
+		}
+	}
+
+	// sing-box refuses to start with an empty group, so a user without
+	// nodes gets a profile that connects directly
+	all := []interface{}{
+		map[string]interface{}{
+			"type":      "selector",
+			"tag":       "proxy",
+			"outbounds": []string{"direct"},
+			"default":   "direct",
+		},
+	}
+	if len(tags) > 0 {
+		all = []interface{}{
+			map[string]interface{}{
+				"type":      "selector",
+				"tag":       "proxy",
+				"outbounds": append([]string{"auto"}, tags...),
+				"default":   "auto",
+			},
+			map[string]interface{}{
+				"type":      "urltest",
+				"tag":       "auto",
+				"outbounds": tags,
+				"url":       "https://www.gstatic.com/generate_204",
+				"interval":  "5m",
+				"tolerance": 50,
+			},
+		}
+	}
+	for _, o := range outbounds {
+		all = append(all, o)
+	}
+	all = append(all, map[string]interface{}{"type": "direct", "tag": "direct"})
+
+	ruleSet := func(tag, kind, name string) map[string]interface{} {
+		return map[string]interface{}{
+			"type":            "remote",
+			"tag":             tag,
+			"format":          "binary",
+			"url":             "https://raw.githubusercontent.com/SagerNet/sing-" + kind + "/rule-set/" + name + ".srs",
+			"download_detour": "proxy",
+		}
+	}
+
+	return map[string]interface{}{
+		"log": map[string]interface{}{
+			"level": "warn",
+		},
+		"dns": map[string]interface{}{
+			"servers": []interface{}{
+				map[string]interface{}{"type": "https", "tag": "remote", "server": "1.1.1.1", "detour": "proxy"},
+				map[string]interface{}{"type": "https", "tag": "local", "server": "223.5.5.5"},
+			},
+			"rules": []interface{}{
+				map[string]interface{}{"rule_set": "geosite-cn", "server": "local"},
+			},
+			"final":    "remote",
+			"strategy": "prefer_ipv4",
+		},
+		"inbounds": []interface{}{
+			map[string]interface{}{
+				"type":         "tun",
+				"tag":          "tun-in",
+				"address":      []string{"172.19.0.1/30", "fdfe:dcba:9876::1/126"},
+				"auto_route":   true,
+				"strict_route": true,
+			},
+			map[string]interface{}{
+				"type":        "mixed",
+				"tag":         "mixed-in",
+				"listen":      "127.0.0.1",
+				"listen_port": 2080,
+			},
+		},
+		"outbounds": all,
+		"route": map[string]interface{}{
+			"rules": []interface{}{
+				map[string]interface{}{"action": "sniff"},
+				map[string]interface{}{"protocol": "dns", "action": "hijack-dns"},
+				map[string]interface{}{"ip_is_private": true, "outbound": "direct"},
+				map[string]interface{}{"rule_set": []string{"geosite-cn", "geoip-cn"}, "outbound": "direct"},
+			},
+			"rule_set": []interface{}{
+				ruleSet("geosite-cn", "geosite", "geosite-cn"),
+				ruleSet("geoip-cn", "geoip", "geoip-cn"),
+			},
+			"final":                   "proxy",
+			"auto_detect_interface":   true,
+			"default_domain_resolver": "local",
+		},
+		"experimental": map[string]interface{}{
+			"cache_file": map[string]interface{}{
+				"enabled": true,
+			},
+		},
+	}
+}
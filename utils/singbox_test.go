@@ -0,0 +1,42 @@
+package utils
+
+import "testing"
+
+func TestSingboxHysteria2Bandwidth(t *testing.T) {
+	node := Node{
+		Title: "hy2",
+		IP:    "203.0.113.7",
+		Port:  "443",
+		Server: map[string]interface{}{
+			"type":      "hysteria2",
+			"up_mbps":   float64(100),
+			"down_mbps": float64(20),
+		},
+	}
+	out := ToSingboxOutbound(node, "password", "")
+	// The server uploads what the client downloads
+	if out["up_mbps"] != float64(20) || out["down_mbps"] != float64(100) {
+		t.Errorf("up_mbps, down_mbps = %v, %v; want 20, 100", out["up_mbps"], out["down_mbps"])
+	}
+}
+
+func TestSingboxConfigWithoutNodes(t *testing.T) {
+	cfg := GenSingboxConfig(nil)
+	tags := map[string]bool{}
+	for _, o := range cfg["outbounds"].([]interface{}) {
+		out := o.(map[string]interface{})
+		tags[out["tag"].(string)] = true
+		if members, ok := out["outbounds"].([]string); ok && len(members) == 0 {
+			t.Errorf("group %v has no outbounds", out["tag"])
+		}
+	}
+	for _, o := range cfg["outbounds"].([]interface{}) {
+		out := o.(map[string]interface{})
+		members, _ := out["outbounds"].([]string)
+		for _, m := range members {
+			if !tags[m] {
+				t.Errorf("group %v: unknown outbound %q", out["tag"], m)
+			}
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+// iniName makes a node title safe for Surge, Loon and Quantumult X proxy
+// lines, where commas and equal signs are separators.
+func iniName(title string) string {
+	return strings.Join(strings.Fields(strings.NewReplacer(",", " ", "=", " ").Replace(title)), " ")
+}
+
+// INITitles returns a copy of the nodes titled with the proxy names Surge,
+// Loon and Quantumult X show. iniName can turn different titles into the
+// same name, so they are made unique again.
+func INITitles(nodes []Node) []Node {
+	out := make([]Node, len(nodes))
+	for i, n := range nodes {
+		n.Title = iniName(n.Title)
+		out[i] = n
+	}
+	UniqueTitles(out)
+	return out
+}
+
+// ToSurgeProxy builds a Surge [Proxy] line for the node. It returns "" for
+// nodes Surge cannot connect to: VLESS and REALITY are not supported at all,
+// and of the V2Ray transports only WebSocket is.
+func ToSurgeProxy(node Node, uuid string) string {
+	serverType, _ := node.Server["type"].(string)
+	t := node.TLS()
+	if t.Reality {
+		return ""
+	}
+	trans := node.Transport()
+	if trans.Type != "tcp" && trans.Type != "ws" {
+		return ""
+	}
+
+	params := []string{node.IP, node.Port}
+	switch serverType {
+	case "shadowsocks":
+		method, _ := node.Server["method"].(string)
+		params = append([]string{"ss"}, params...)
+		params = append(params, "encrypt-method="+method, "password="+uuid, "udp-relay=true")
+	case "vmess":
+		params = append([]string{"vmess"}, params...)
+		params = append(params, "username="+uuid, "vmess-aead=true")
+		if t.Enabled {
+			params = append(params, "tls=true")
+		}
+	case "trojan", "anytls":
+		params = append([]string{serverType}, params...)
+		params = append(params, "password="+uuid)
+	case "hysteria2":
+		params = append([]string{"hysteria2"}, params...)
+		params = append(params, "password="+uuid)
+	case "tuic":
+		params = append([]string{"tuic-v5"}, params...)
+		params = append(params, "password="+uuid, "uuid="+uuid, "alpn=h3")
+	default:
+		// vless and naive have no Surge client
+		return ""
+	}
+
+	if trans.Type == "ws" {
+		params = append(params, "ws=true")
+		if trans.Path != "" {
+			params = append(params, "ws-path="+trans.Path)
+		}
+		if trans.Host != "" {
+			params = append(params, "ws-headers=Host:"+trans.Host)
+		}
+	}
+	if t.Enabled && t.ServerName != "" {
+		params = append(params, "sni="+t.ServerName)
+	}
+
+	return iniName(node.Title) + " = " + strings.Join(params, ", ")
+}
+
+// iniProxyNames returns the names of "Name = ..." proxy lines.
+func iniProxyNames(proxies []string) []string {
+	names := make([]string, 0, len(proxies))
+	for _, p := range proxies {
+		name, _, _ := strings.Cut(p, " = ")
+		names = append(names, name)
+	}
+	return names
+}
+
+// GenSurgeConfig wraps proxy lines into a complete Surge managed profile
+// with a select group, an auto url-test group and mainland China rules.
+// managedURL is where Surge refreshes the profile from.
+func GenSurgeConfig(proxies []string, managedURL string) string {
+	var b strings.Builder
+	if managedURL != "" {
+		fmt.Fprintf(&b, "#!MANAGED-CONFIG %s interval=43200 strict=false\n\n", managedURL)
+	}
+	b.WriteString("[General]\n")
+	b.WriteString("loglevel = notify\n")
+	b.WriteString("dns-server = system, 223.5.5.5, 119.29.29.29\n")
+	b.WriteString("skip-proxy = 127.0.0.1, 192.168.0.0/16, 10.0.0.0/8, 172.16.0.0/12, localhost, *.local\n")
+	b.WriteString("internet-test-url = http://www.gstatic.com/generate_204\n")
+	b.WriteString("proxy-test-url = http://www.gstatic.com/generate_204\n\n")
+
+	b.WriteString("[Proxy]\n")
+	for _, p := range proxies {
+		b.WriteString(p + "\n")
+	}
+
+	names := iniProxyNames(proxies)
+	b.WriteString("\n[Proxy Group]\n")
+	b.WriteString("Proxy = select, " + strings.Join(append([]string{"Auto"}, names...), ", ") + "\n")
+	if len(names) > 0 {
+		b.WriteString("Auto = url-test, " + strings.Join(names, ", ") + ", interval=600, tolerance=50\n")
+	} else {
+		b.WriteString("Auto = select, DIRECT\n")
+	}
+
+	b.WriteString("\n[Rule]\n")
+	b.WriteString("GEOIP,CN,DIRECT\n")
+	b.WriteString("FINAL,Proxy,dns-failed\n")
+	return b.String()
+}
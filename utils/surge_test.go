@@ -0,0 +1,137 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+const iniTestUUID = "6f1a8e2c-4b7d-4c3e-9a51-2d8f0b6e7c19"
+
+// iniTestNodes covers every server type and the TLS and transport variants
+// the INI style renderers treat differently.
+var iniTestNodes = []struct {
+	name string
+	node Node
+}{
+	{"shadowsocks", Node{Title: "ss", IP: "203.0.113.7", Port: "8388", Server: map[string]interface{}{
+		"type": "shadowsocks", "method": "2022-blake3-aes-128-gcm",
+	}}},
+	{"vmess", Node{Title: "vmess", IP: "203.0.113.7", Port: "10086", Server: map[string]interface{}{
+		"type": "vmess",
+	}}},
+	{"vmess ws tls", Node{Title: "vmess, ws", IP: "203.0.113.7", Port: "443", Server: map[string]interface{}{
+		"type":      "vmess",
+		"transport": map[string]interface{}{"type": "ws", "path": "/ws", "headers": map[string]interface{}{"Host": "cdn.example.com"}},
+		"tls":       map[string]interface{}{"enabled": true, "server_name": "example.com"},
+	}}},
+	{"vmess grpc", Node{Title: "grpc", IP: "203.0.113.7", Port: "443", Server: map[string]interface{}{
+		"type":      "vmess",
+		"transport": map[string]interface{}{"type": "grpc", "service_name": "g"},
+	}}},
+	{"vless reality", Node{Title: "reality", IP: "203.0.113.7", Port: "443", Server: map[string]interface{}{
+		"type": "vless",
+		"flow": "xtls-rprx-vision",
+		"tls": map[string]interface{}{"enabled": true, "server_name": "www.example.com", "reality": map[string]interface{}{
+			"enabled": true, "public_key": "Ek7c1Vdq3VQpJ2V5zN6kYJk2m0R7pH8sXw9aLr4fT1o", "short_id": []interface{}{"6ba85179e30d4fc2"},
+		}},
+	}}},
+	{"trojan", Node{Title: "trojan", IP: "2001:db8::7", Port: "443", Server: map[string]interface{}{
+		"type": "trojan",
+		"tls":  map[string]interface{}{"enabled": true, "server_name": "example.com"},
+	}}},
+	{"hysteria2", Node{Title: "hy2", IP: "203.0.113.7", Port: "443", Server: map[string]interface{}{
+		"type": "hysteria2",
+		"tls":  map[string]interface{}{"enabled": true, "server_name": "example.com"},
+	}}},
+	{"tuic", Node{Title: "tuic", IP: "203.0.113.7", Port: "443", Server: map[string]interface{}{
+		"type": "tuic",
+		"tls":  map[string]interface{}{"enabled": true, "server_name": "example.com"},
+	}}},
+	{"anytls", Node{Title: "anytls", IP: "203.0.113.7", Port: "443", Server: map[string]interface{}{
+		"type": "anytls",
+		"tls":  map[string]interface{}{"enabled": true, "server_name": "example.com"},
+	}}},
+	{"naive", Node{Title: "naive", IP: "203.0.113.7", Port: "443", Server: map[string]interface{}{
+		"type": "naive",
+		"tls":  map[string]interface{}{"enabled": true, "server_name": "example.com"},
+	}}},
+}
+
+// checkGolden renders every test node and compares it with want, where ""
+// means the node must be skipped.
+func checkGolden(t *testing.T, render func(Node, string) string, want map[string]string) {
+	t.Helper()
+	for _, tc := range iniTestNodes {
+		w, ok := want[tc.name]
+		if !ok {
+			t.Errorf("%s: no expected output", tc.name)
+			continue
+		}
+		if got := render(tc.node, iniTestUUID); got != w {
+			t.Errorf("%s:\ngot  %q\nwant %q", tc.name, got, w)
+		}
+	}
+}
+
+func TestSurgeProxy(t *testing.T) {
+	checkGolden(t, ToSurgeProxy, map[string]string{
+		"shadowsocks":   "ss = ss, 203.0.113.7, 8388, encrypt-method=2022-blake3-aes-128-gcm, password=6f1a8e2c-4b7d-4c3e-9a51-2d8f0b6e7c19, udp-relay=true",
+		"vmess":         "vmess = vmess, 203.0.113.7, 10086, username=6f1a8e2c-4b7d-4c3e-9a51-2d8f0b6e7c19, vmess-aead=true",
+		"vmess ws tls":  "vmess ws = vmess, 203.0.113.7, 443, username=6f1a8e2c-4b7d-4c3e-9a51-2d8f0b6e7c19, vmess-aead=true, tls=true, ws=true, ws-path=/ws, ws-headers=Host:cdn.example.com, sni=example.com",
+		"vmess grpc":    "",
+		"vless reality": "",
+		"trojan":        "trojan = trojan, 2001:db8::7, 443, password=6f1a8e2c-4b7d-4c3e-9a51-2d8f0b6e7c19, sni=example.com",
+		"hysteria2":     "hy2 = hysteria2, 203.0.113.7, 443, password=6f1a8e2c-4b7d-4c3e-9a51-2d8f0b6e7c19, sni=example.com",
+		"tuic":          "tuic = tuic-v5, 203.0.113.7, 443, password=6f1a8e2c-4b7d-4c3e-9a51-2d8f0b6e7c19, uuid=6f1a8e2c-4b7d-4c3e-9a51-2d8f0b6e7c19, alpn=h3, sni=example.com",
+		"anytls":        "anytls = anytls, 203.0.113.7, 443, password=6f1a8e2c-4b7d-4c3e-9a51-2d8f0b6e7c19, sni=example.com",
+		"naive":         "",
+	})
+}
+
+func TestINITitles(t *testing.T) {
+	server := map[string]interface{}{"type": "trojan"}
+	var nodes []Node
+	for _, title := range []string{"a,b", "a b", " a=b ", "a b 2", "c"} {
+		nodes = append(nodes, Node{Title: title, IP: "203.0.113.7", Port: "443", Server: server})
+	}
+	got := INITitles(nodes)
+	want := []string{"a b", "a b 2", "a b 3", "a b 2 2", "c"}
+	for i := range want {
+		if got[i].Title != want[i] {
+			t.Errorf("title %d = %q, want %q", i, got[i].Title, want[i])
+		}
+	}
+	if nodes[0].Title != "a,b" {
+		t.Errorf("INITitles modified the nodes passed in: %q", nodes[0].Title)
+	}
+
+	var proxies []string
+	for _, n := range got {
+		proxies = append(proxies, ToSurgeProxy(n, iniTestUUID))
+	}
+	for _, cfg := range []string{GenSurgeConfig(proxies, ""), GenLoonConfig(proxies)} {
+		_, groups, _ := strings.Cut(cfg, "[Proxy Group]\n")
+		groups, _, _ = strings.Cut(groups, "\n\n")
+		for _, line := range strings.Split(groups, "\n") {
+			seen := map[string]bool{}
+			name, members, _ := strings.Cut(line, " = ")
+			for _, m := range strings.Split(members, ",") {
+				m = strings.TrimSpace(m)
+				if strings.Contains(m, "=") {
+					continue
+				}
+				if seen[m] {
+					t.Errorf("group %s lists %q twice", name, m)
+				}
+				seen[m] = true
+			}
+		}
+	}
+}
+
+func TestSurgeConfigWithoutNodes(t *testing.T) {
+	cfg := GenSurgeConfig(nil, "")
+	if !strings.Contains(cfg, "Auto = select, DIRECT\n") {
+		t.Errorf("Auto group without nodes is not DIRECT:\n%s", cfg)
+	}
+}
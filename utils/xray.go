@@ -0,0 +1,218 @@
+package utils
+
+import "strconv"
+
+// xrayNodeTagPrefix starts the tag of every node outbound in GenXrayConfig.
+// The observatory and the balancer select outbounds by tag prefix, so node
+// titles cannot be used as tags: a node titled "d" would select "direct".
+const xrayNodeTagPrefix = "node-"
+
+// ToXrayOutbound builds an Xray client outbound for the node, including
+// REALITY and XHTTP stream settings. It returns nil for protocols Xray has
+// no client for, such as naive or TUIC.
+func ToXrayOutbound(node Node, uuid string) map[string]interface{} {
+	serverType, _ := node.Server["type"].(string)
+	port := node.PortNumber()
+
+	out := map[string]interface{}{
+		"tag":      node.Title,
+		"protocol": serverType,
+	}
+
+	switch serverType {
+	case "vless":
+		user := map[string]interface{}{
+			"id":         uuid,
+			"encryption": "none",
+		}
+		if flow, ok := node.Server["flow"].(string); ok && flow != "" {
+			user["flow"] = flow
+		}
+		out["settings"] = map[string]interface{}{
+			"vnext": []interface{}{
+				map[string]interface{}{"address": node.IP, "port": port, "users": []interface{}{user}},
+			},
+		}
+	case "vmess":
+		out["settings"] = map[string]interface{}{
+			"vnext": []interface{}{
+				map[string]interface{}{"address": node.IP, "port": port, "users": []interface{}{
+					map[string]interface{}{"id": uuid, "alterId": 0, "security": "auto"},
+				}},
+			},
+		}
+	case "trojan":
+		out["settings"] = map[string]interface{}{
+			"servers": []interface{}{
+				map[string]interface{}{"address": node.IP, "port": port, "password": uuid},
+			},
+		}
+	case "shadowsocks":
+		out["settings"] = map[string]interface{}{
+			"servers": []interface{}{
+				map[string]interface{}{"address": node.IP, "port": port, "method": node.Server["method"], "password": uuid},
+			},
+		}
+	default:
+		return nil
+	}
+
+	trans := node.Transport()
+	stream := map[string]interface{}{
+		"network":  trans.Type,
+		"security": "none",
+	}
+	switch trans.Type {
+	case "tcp":
+	case "ws":
+		ws := map[string]interface{}{"path": trans.Path}
+		if trans.Host != "" {
+			ws["host"] = trans.Host
+		}
+		stream["wsSettings"] = ws
+	case "httpupgrade":
+		hu := map[string]interface{}{"path": trans.Path}
+		if trans.Host != "" {
+			hu["host"] = trans.Host
+		}
+		stream["httpupgradeSettings"] = hu
+	case "grpc":
+		stream["grpcSettings"] = map[string]interface{}{"serviceName": trans.ServiceName}
+	case "xhttp":
+		stream["network"] = "xhttp"
+		path := trans.Path
+		if path == "" {
+			path = "/xhttp"
+		}
+		xhttp := map[string]interface{}{
+			"path": path,
+			"mode": "auto",
+		}
+		if trans.Host != "" {
+			xhttp["host"] = trans.Host
+		}
+		if trans.Mode != "" {
+			xhttp["mode"] = trans.Mode
+		}
+		if trans.Extra != nil {
+			xhttp["extra"] = trans.Extra
+		}
+		stream["xhttpSettings"] = xhttp
+	default:
+		// Including sing-box's HTTP transport: XHTTP is a different
+		// protocol and Xray has nothing else that speaks it
+		return nil
+	}
+
+	if t := node.TLS(); t.Enabled {
+		fp := node.Fingerprint("chrome")
+		if t.Reality {
+			stream["security"] = "reality"
+			stream["realitySettings"] = map[string]interface{}{
+				"serverName":  t.ServerName,
+				"publicKey":   t.PublicKey,
+				"shortId":     t.ShortID,
+				"fingerprint": fp,
+			}
+		} else {
+			stream["security"] = "tls"
+			tls := map[string]interface{}{
+				"serverName":  t.ServerName,
+				"fingerprint": fp,
+			}
+			if len(t.ALPN) > 0 {
+				tls["alpn"] = t.ALPN
+			}
+			stream["tlsSettings"] = tls
+		}
+	}
+	out["streamSettings"] = stream
+
+	return out
+}
+
+// GenXrayConfig wraps node outbounds into a complete Xray client config with
+// local SOCKS and HTTP inbounds and a least-ping balancer across all nodes.
+// Node outbounds are tagged "node-<n> <title>".
+func GenXrayConfig(outbounds []map[string]interface{}) map[string]interface{} {
+	all := make([]interface{}, 0, len(outbounds)+2)
+	for i, o := range outbounds {
+		node := make(map[string]interface{}, len(o))
+		for k, v := range o {
+			node[k] = v
+		}
+		tag := xrayNodeTagPrefix + strconv.Itoa(i+1)
+		if title, _ := o["tag"].(string); title != "" {
+			tag += " " + title
+		}
+		node["tag"] = tag
+		all = append(all, node)
+	}
+	// Used until the observatory has probed the nodes, and without any
+	fallback := "direct"
+	if len(all) > 0 {
+		fallback = all[0].(map[string]interface{})["tag"].(string)
+	}
+	all = append(all,
+		map[string]interface{}{"tag": "direct", "protocol": "freedom"},
+		map[string]interface{}{"tag": "block", "protocol": "blackhole"},
+	)
+
+	return map[string]interface{}{
+		"log": map[string]interface{}{
+			"loglevel": "warning",
+		},
+		"dns": map[string]interface{}{
+			"servers": []interface{}{
+				"https://1.1.1.1/dns-query",
+				map[string]interface{}{
+					"address": "223.5.5.5",
+					"domains": []string{"geosite:cn"},
+				},
+			},
+		},
+		"inbounds": []interface{}{
+			map[string]interface{}{
+				"tag":      "socks",
+				"listen":   "127.0.0.1",
+				"port":     10808,
+				"protocol": "socks",
+				"settings": map[string]interface{}{"udp": true},
+				"sniffing": map[string]interface{}{
+					"enabled":      true,
+					"destOverride": []string{"http", "tls", "quic"},
+				},
+			},
+			map[string]interface{}{
+				"tag":      "http",
+				"listen":   "127.0.0.1",
+				"port":     10809,
+				"protocol": "http",
+			},
+		},
+		"outbounds": all,
+		"observatory": map[string]interface{}{
+			"subjectSelector":   []string{xrayNodeTagPrefix},
+			"probeURL":          "https://www.gstatic.com/generate_204",
+			"probeInterval":     "5m",
+			"enableConcurrency": true,
+		},
+		"routing": map[string]interface{}{
+			"domainStrategy": "IPIfNonMatch",
+			"balancers": []interface{}{
+				map[string]interface{}{
+					"tag":         "auto",
+					"selector":    []string{xrayNodeTagPrefix},
+					"strategy":    map[string]interface{}{"type": "leastPing"},
+					"fallbackTag": fallback,
+				},
+			},
+			"rules": []interface{}{
+				map[string]interface{}{"type": "field", "ip": []string{"geoip:private"}, "outboundTag": "direct"},
+				map[string]interface{}{"type": "field", "domain": []string{"geosite:cn"}, "outboundTag": "direct"},
+				map[string]interface{}{"type": "field", "ip": []string{"geoip:cn"}, "outboundTag": "direct"},
+				map[string]interface{}{"type": "field", "network": "tcp,udp", "balancerTag": "auto"},
+			},
+		},
+	}
+}
@@ -0,0 +1,106 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestXrayBalancerSelectsOnlyNodes(t *testing.T) {
+	var outbounds []map[string]interface{}
+	for _, title := range []string{"d", "b", "direct", "d"} {
+		outbounds = append(outbounds, ToXrayOutbound(Node{
+			Title:  title,
+			IP:     "203.0.113.7",
+			Port:   "443",
+			Server: map[string]interface{}{"type": "trojan"},
+		}, "password"))
+	}
+	cfg := GenXrayConfig(outbounds)
+
+	routing := cfg["routing"].(map[string]interface{})
+	balancer := routing["balancers"].([]interface{})[0].(map[string]interface{})
+	selector := balancer["selector"].([]string)
+	observed := cfg["observatory"].(map[string]interface{})["subjectSelector"].([]string)
+
+	// Xray matches selectors as tag prefixes
+	selects := func(selector []string, tag string) bool {
+		for _, s := range selector {
+			if strings.HasPrefix(tag, s) {
+				return true
+			}
+		}
+		return false
+	}
+	seen := map[string]bool{}
+	nodes := 0
+	for _, o := range cfg["outbounds"].([]interface{}) {
+		out := o.(map[string]interface{})
+		tag := out["tag"].(string)
+		if seen[tag] {
+			t.Errorf("duplicate tag %q", tag)
+		}
+		seen[tag] = true
+
+		isNode := out["protocol"] == "trojan"
+		if isNode {
+			nodes++
+		}
+		if selects(selector, tag) != isNode || selects(observed, tag) != isNode {
+			t.Errorf("outbound %q: selected = %v, want %v", tag, selects(selector, tag), isNode)
+		}
+	}
+	if nodes != len(outbounds) {
+		t.Errorf("got %d node outbounds, want %d", nodes, len(outbounds))
+	}
+	if fallback := balancer["fallbackTag"]; fallback != "node-1 d" {
+		t.Errorf("fallbackTag = %v, want the first node", fallback)
+	}
+	if outbounds[0]["tag"] != "d" {
+		t.Errorf("GenXrayConfig modified the outbound passed in: tag %v", outbounds[0]["tag"])
+	}
+}
+
+func TestXrayConfigWithoutNodes(t *testing.T) {
+	cfg := GenXrayConfig(nil)
+	balancer := cfg["routing"].(map[string]interface{})["balancers"].([]interface{})[0].(map[string]interface{})
+	if fallback := balancer["fallbackTag"]; fallback != "direct" {
+		t.Errorf("fallbackTag = %v, want direct", fallback)
+	}
+}
+
+func TestXrayTransports(t *testing.T) {
+	for _, tc := range []struct {
+		transport string
+		network   string // "" if the node must be skipped
+	}{
+		{"ws", "ws"},
+		{"httpupgrade", "httpupgrade"},
+		{"grpc", "grpc"},
+		{"xhttp", "xhttp"},
+		{"http", ""},
+		{"quic", ""},
+	} {
+		out := ToXrayOutbound(Node{
+			Title: "n",
+			IP:    "203.0.113.7",
+			Port:  "443",
+			Server: map[string]interface{}{
+				"type":      "vless",
+				"transport": map[string]interface{}{"type": tc.transport, "path": "/p"},
+			},
+		}, "6f1a8e2c-4b7d-4c3e-9a51-2d8f0b6e7c19")
+		if tc.network == "" {
+			if out != nil {
+				t.Errorf("%s: got %v, want the node skipped", tc.transport, out)
+			}
+			continue
+		}
+		if out == nil {
+			t.Errorf("%s: node skipped", tc.transport)
+			continue
+		}
+		if network := out["streamSettings"].(map[string]interface{})["network"]; network != tc.network {
+			t.Errorf("%s: network = %v, want %s", tc.transport, network, tc.network)
+		}
+	}
+}